@@ -0,0 +1,214 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Names of the algorithm presets.
+const (
+	// AlgorithmsModern allows only algorithms without known
+	// weaknesses: curve25519 and NIST ECDH key exchanges, AES-GCM
+	// and AES-CTR ciphers, SHA-2 MACs, and Ed25519, ECDSA, and
+	// RSA SHA-2 host keys. It leaves out ChaCha20-Poly1305 and the
+	// encrypt-then-MAC MACs, which are open to the Terrapin attack
+	// (CVE-2023-48795) unless the server supports strict key
+	// exchange, e.g., OpenSSH 9.6 and later. Add them to Ciphers
+	// and MACs for servers that do.
+	AlgorithmsModern = "modern"
+
+	// AlgorithmsCompat adds the algorithms that AlgorithmsModern
+	// leaves out and the older ones that legacy appliances may
+	// need: ChaCha20-Poly1305, encrypt-then-MAC MACs, SHA-1
+	// Diffie-Hellman key exchanges, CBC and RC4 ciphers, SHA-1
+	// MACs, and SHA-1 RSA and DSA host keys.
+	AlgorithmsCompat = "compat"
+)
+
+// Algorithms lists the SSH algorithms offered to the server in order
+// of preference. A list that is empty is taken from Preset or, if
+// Preset is empty, from the golang.org/x/crypto/ssh defaults.
+type Algorithms struct {
+	// Preset is AlgorithmsModern, AlgorithmsCompat, or empty.
+	Preset string
+
+	// KeyExchanges lists the key exchange algorithms, e.g.,
+	// "curve25519-sha256@libssh.org".
+	KeyExchanges []string
+
+	// Ciphers lists the ciphers, e.g., "aes128-gcm@openssh.com".
+	Ciphers []string
+
+	// MACs lists the message authentication codes, e.g.,
+	// "hmac-sha2-256-etm@openssh.com".
+	MACs []string
+
+	// HostKeys lists the host key algorithms, e.g.,
+	// "ssh-ed25519".
+	HostKeys []string
+}
+
+// algorithmPresets maps preset names to their algorithms.
+var algorithmPresets = map[string]Algorithms{
+	AlgorithmsModern: {
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		},
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		HostKeys: []string{
+			ssh.CertAlgoED25519v01,
+			ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+			ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+		},
+	},
+	AlgorithmsCompat: {
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256",
+			"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		},
+		Ciphers: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+			"chacha20-poly1305@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+			"aes128-cbc", "3des-cbc",
+			"arcfour256", "arcfour128", "arcfour",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+			"hmac-sha1", "hmac-sha1-96",
+		},
+		HostKeys: []string{
+			ssh.CertAlgoED25519v01,
+			ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+			ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+			ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+			ssh.KeyAlgoED25519,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+			ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		},
+	},
+}
+
+// supportedAlgorithms lists the algorithms golang.org/x/crypto/ssh
+// implements. It ignores unknown ciphers, key exchanges, and MACs
+// instead of rejecting them, so a misspelled name would go unnoticed
+// until the handshake fails.
+var supportedAlgorithms = Algorithms{
+	KeyExchanges: []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+	},
+	Ciphers: []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-cbc", "3des-cbc",
+		"arcfour256", "arcfour128", "arcfour",
+	},
+	MACs: []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+		"hmac-sha1", "hmac-sha1-96",
+	},
+	HostKeys: []string{
+		ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01,
+		ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+		ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoED25519v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+		ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		ssh.KeyAlgoED25519,
+	},
+}
+
+// AlgorithmPreset returns the algorithms of the named preset.
+func AlgorithmPreset(name string) (Algorithms, error) {
+	preset, ok := algorithmPresets[name]
+	if !ok {
+		return Algorithms{}, fmt.Errorf("run: unknown SSH algorithm preset %q, expected %q or %q",
+			name, AlgorithmsModern, AlgorithmsCompat)
+	}
+
+	return Algorithms{
+		Preset:       name,
+		KeyExchanges: append([]string(nil), preset.KeyExchanges...),
+		Ciphers:      append([]string(nil), preset.Ciphers...),
+		MACs:         append([]string(nil), preset.MACs...),
+		HostKeys:     append([]string(nil), preset.HostKeys...),
+	}, nil
+}
+
+// resolve returns the algorithms with the empty lists taken from the
+// preset. It fails if a list names an algorithm that is not supported.
+func (a Algorithms) resolve() (Algorithms, error) {
+	if a.Preset != "" {
+		preset, err := AlgorithmPreset(a.Preset)
+		if err != nil {
+			return Algorithms{}, err
+		}
+		if len(a.KeyExchanges) == 0 {
+			a.KeyExchanges = preset.KeyExchanges
+		}
+		if len(a.Ciphers) == 0 {
+			a.Ciphers = preset.Ciphers
+		}
+		if len(a.MACs) == 0 {
+			a.MACs = preset.MACs
+		}
+		if len(a.HostKeys) == 0 {
+			a.HostKeys = preset.HostKeys
+		}
+	}
+	for _, list := range []struct {
+		kind            string
+		names, supports []string
+	}{
+		{"key exchange", a.KeyExchanges, supportedAlgorithms.KeyExchanges},
+		{"cipher", a.Ciphers, supportedAlgorithms.Ciphers},
+		{"MAC", a.MACs, supportedAlgorithms.MACs},
+		{"host key algorithm", a.HostKeys, supportedAlgorithms.HostKeys},
+	} {
+		for _, name := range list.names {
+			if !contains(list.supports, name) {
+				return Algorithms{}, fmt.Errorf("run: unsupported SSH %s %q", list.kind, name)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// apply sets the algorithms of config.
+func (a Algorithms) apply(config *ssh.ClientConfig) {
+	config.KeyExchanges = a.KeyExchanges
+	config.Ciphers = a.Ciphers
+	config.MACs = a.MACs
+	config.HostKeyAlgorithms = a.HostKeys
+}
+
+// BannerFunc receives the banner a server sends before authentication,
+// e.g., a legal notice. Returning an error aborts the connection.
+type BannerFunc func(message string) error
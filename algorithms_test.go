@@ -0,0 +1,148 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAlgorithmPreset(t *testing.T) {
+	modern, err := run.AlgorithmPreset(run.AlgorithmsModern)
+	require.NoError(t, err)
+	compat, err := run.AlgorithmPreset(run.AlgorithmsCompat)
+	require.NoError(t, err)
+	assert.NotContains(t, modern.KeyExchanges, "diffie-hellman-group1-sha1")
+	assert.NotContains(t, modern.Ciphers, "3des-cbc")
+	assert.NotContains(t, modern.MACs, "hmac-sha1")
+	assert.NotContains(t, modern.HostKeys, ssh.KeyAlgoRSA)
+	assert.Contains(t, compat.KeyExchanges, "diffie-hellman-group1-sha1")
+	assert.Contains(t, compat.Ciphers, "3des-cbc")
+	assert.Contains(t, compat.MACs, "hmac-sha1")
+	assert.Contains(t, compat.HostKeys, ssh.KeyAlgoRSA)
+	assert.NotContains(t, modern.Ciphers, "chacha20-poly1305@openssh.com")
+	assert.NotContains(t, modern.MACs, "hmac-sha2-256-etm@openssh.com")
+	assert.Contains(t, compat.Ciphers, "chacha20-poly1305@openssh.com")
+	assert.Contains(t, compat.MACs, "hmac-sha2-256-etm@openssh.com")
+
+	// The returned lists are copies.
+	modern.Ciphers[0] = "none"
+	again, err := run.AlgorithmPreset(run.AlgorithmsModern)
+	require.NoError(t, err)
+	assert.NotEqual(t, "none", again.Ciphers[0])
+
+	_, err = run.AlgorithmPreset("legacy")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	_, err = run.NewRemote(run.RemoteConfig{
+		Credentials: run.Credentials{Password: "secret"},
+		Algorithms:  run.Algorithms{Preset: "legacy"},
+	})
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	// Misspelled algorithm names are rejected, with or without a
+	// preset.
+	for _, algorithms := range []run.Algorithms{
+		{Ciphers: []string{"aes128-ctr", "aes129-ctr"}},
+		{Preset: run.AlgorithmsModern, KeyExchanges: []string{"curve25519-sha265"}},
+		{Preset: run.AlgorithmsCompat, MACs: []string{"hmac-sha2-256-etm"}},
+		{HostKeys: []string{"ssh-ed25519-cert"}},
+	} {
+		_, err = run.NewRemote(run.RemoteConfig{
+			Credentials: run.Credentials{Password: "secret"},
+			Algorithms:  algorithms,
+		})
+		t.Logf("err = %v", err)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "run: unsupported SSH ")
+	}
+}
+
+func TestRemote_Algorithms(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     []string
+		algorithms run.Algorithms
+		ok         bool
+	}{
+		{"default", nil, run.Algorithms{}, true},
+		{"modern", nil, run.Algorithms{Preset: run.AlgorithmsModern}, true},
+		{"compat", nil, run.Algorithms{Preset: run.AlgorithmsCompat}, true},
+		{"compat reaches legacy cipher", []string{"aes128-cbc"}, run.Algorithms{Preset: run.AlgorithmsCompat}, true},
+		{"modern refuses legacy cipher", []string{"aes128-cbc"}, run.Algorithms{Preset: run.AlgorithmsModern}, false},
+		{"modern refuses chacha20-poly1305", []string{"chacha20-poly1305@openssh.com"},
+			run.Algorithms{Preset: run.AlgorithmsModern}, false},
+		{"explicit cipher", []string{"aes256-ctr"},
+			run.Algorithms{Preset: run.AlgorithmsModern, Ciphers: []string{"aes256-ctr"}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := authServerConfig("s3cret")
+			config.Ciphers = test.server
+			server := newTestSSHServer(t, config)
+			creds := server.Credentials("deploy")
+			creds.Password = "s3cret"
+			r, err := run.NewRemote(run.RemoteConfig{Credentials: creds, Algorithms: test.algorithms})
+			require.NoError(t, err)
+			if test.algorithms.Preset != "" {
+				assert.NotEmpty(t, r.Algorithms.KeyExchanges)
+				assert.NotEmpty(t, r.Algorithms.HostKeys)
+			}
+			stdout, _, _, err := r.Run("echo", "ok")
+			if test.ok {
+				require.NoError(t, err)
+				assert.Equal(t, "ok\n", stdout)
+				return
+			}
+			t.Logf("err = %v", err)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "no common algorithm")
+			assert.True(t, errors.Is(err, run.ErrConnectFailed))
+		})
+	}
+}
+
+func TestRemote_Banner(t *testing.T) {
+	config := authServerConfig("s3cret")
+	config.BannerCallback = func(ssh.ConnMetadata) string {
+		return "Authorized use only\n"
+	}
+	server := newTestSSHServer(t, config)
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+
+	var banners []string
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials: creds,
+		BannerCallback: func(message string) error {
+			banners = append(banners, message)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, r.ServerVersion())
+	assert.Empty(t, r.Banner())
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Authorized use only\n"}, banners)
+	assert.Equal(t, "Authorized use only\n", r.Banner())
+	assert.True(t, strings.HasPrefix(r.ServerVersion(), "SSH-2.0-"), r.ServerVersion())
+
+	// An error from the callback aborts the connection.
+	r.BannerCallback = func(message string) error {
+		return errors.New("unexpected banner")
+	}
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected banner")
+}
@@ -0,0 +1,558 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AuthMethod names an SSH authentication method.
+type AuthMethod string
+
+const (
+	// AuthAgent authenticates with the keys held by the ssh-agent
+	// listening on $SSH_AUTH_SOCK.
+	AuthAgent AuthMethod = "agent"
+
+	// AuthPublicKey authenticates with the private key files in
+	// Credentials.PrivateKeyFilename and
+	// Credentials.PrivateKeyFilenames.
+	AuthPublicKey AuthMethod = "publickey"
+
+	// AuthPassword authenticates with Credentials.Password or
+	// Credentials.PasswordSource.
+	AuthPassword AuthMethod = "password"
+
+	// AuthKeyboardInteractive answers the server's challenges with
+	// Credentials.KeyboardInteractive or, if it is nil, with the
+	// password.
+	AuthKeyboardInteractive AuthMethod = "keyboard-interactive"
+)
+
+// DefaultAuthMethods is the order authentication methods are tried in
+// if Credentials.AuthMethods is empty.
+var DefaultAuthMethods = []AuthMethod{
+	AuthAgent,
+	AuthPublicKey,
+	AuthPassword,
+	AuthKeyboardInteractive,
+}
+
+// PassphraseFunc returns the passphrase of an encrypted private key
+// file. The returned slice is zeroed after use.
+type PassphraseFunc func(filename string) ([]byte, error)
+
+// ChallengeFunc answers keyboard-interactive challenges. It is called
+// with the prompts sent by the server and must return one answer per
+// question. Questions whose echos entry is false should not be echoed
+// if they are shown to a user, e.g., passwords.
+type ChallengeFunc func(user, instruction string, questions []string, echos []bool) ([]string, error)
+
+// AuthInfo describes how a Remote authenticated.
+type AuthInfo struct {
+	// Method is the authentication method that succeeded.
+	Method AuthMethod
+
+	// Source is where the credentials came from: the private key
+	// or certificate file, the comment or fingerprint of the agent
+	// key, or the provider of the password.
+	Source string
+
+	// KeyID is the key ID of the SSH user certificate, if one was
+	// used.
+	KeyID string
+}
+
+// String returns the method and source, e.g., "publickey
+// (/home/me/.ssh/id_rsa)".
+func (a AuthInfo) String() string {
+	s := string(a.Method)
+	if a.Source != "" {
+		s += " (" + a.Source + ")"
+	}
+	if a.KeyID != "" {
+		s += fmt.Sprintf(" certificate %q", a.KeyID)
+	}
+
+	return s
+}
+
+// sshAuth holds the state of the authentication methods used for one
+// connection.
+type sshAuth struct {
+	r         *Remote
+	agentConn net.Conn
+	signers   []ssh.Signer
+
+	// attempted is the method tried last. Once the connection is
+	// established it is the method that succeeded.
+	attempted AuthInfo
+
+	// certErr is the first certificate skipped because it is not
+	// valid. It is reported if authentication fails.
+	certErr error
+
+	// started is true once the server asked for credentials, so a
+	// failed handshake is an authentication failure.
+	started bool
+
+	// secrets holds the passwords, passphrases and answers resolved
+	// for this connection. They are hidden in its errors only and
+	// dropped once the connection is established.
+	secrets *Redactor
+}
+
+// getSSHAuths returns the authentication methods in the order of
+// Credentials.AuthMethods. Methods without credentials are skipped. The
+// agent and private key files are combined into a single publickey
+// method since SSH clients may only try each method once. The returned
+// sshAuth must be closed once the connection is established.
+func (r *Remote) getSSHAuths() (*sshAuth, []ssh.AuthMethod, error) {
+	a := &sshAuth{r: r}
+	order := r.Credentials.AuthMethods
+	if len(order) == 0 {
+		order = DefaultAuthMethods
+	}
+	var auths []ssh.AuthMethod
+	var names []string
+	publicKeys := false
+	for _, method := range order {
+		n := len(a.signers)
+		switch method {
+		case AuthAgent:
+			a.agentSigners()
+		case AuthPublicKey:
+			if err := a.keySigners(); err != nil {
+				a.close()
+				return nil, nil, err
+			}
+		case AuthPassword:
+			if r.hasPassword() {
+				auths = append(auths, ssh.PasswordCallback(a.password))
+				names = append(names, string(method))
+			}
+		case AuthKeyboardInteractive:
+			if r.Credentials.KeyboardInteractive != nil || r.hasPassword() {
+				auths = append(auths, ssh.KeyboardInteractive(a.challenge))
+				names = append(names, string(method))
+			}
+		default:
+			a.close()
+			return nil, nil, fmt.Errorf("run: unknown SSH authentication method %q", method)
+		}
+		if len(a.signers) > n {
+			if !publicKeys {
+				publicKeys = true
+				auths = append(auths, ssh.PublicKeysCallback(a.publicKeys))
+			}
+			names = append(names, string(method))
+		}
+	}
+	if len(auths) == 0 {
+		a.close()
+		return nil, nil, fmt.Errorf("run: no SSH authentication methods available for %s@%s",
+			r.Credentials.Username, r.Credentials.Hostname)
+	}
+	logAttrs(r.Logger, slog.LevelDebug, "ssh auth methods",
+		append(r.logAttrs(), slog.String("methods", strings.Join(names, ",")))...)
+
+	return a, auths, nil
+}
+
+// hasPassword returns true if a password is configured.
+func (r *Remote) hasPassword() bool {
+	return r.Credentials.Password != "" || r.Credentials.PasswordSource != nil
+}
+
+// close closes the connection to the agent and drops the secrets.
+func (a *sshAuth) close() {
+	if a.agentConn != nil {
+		a.agentConn.Close() // nolint
+		a.agentConn = nil
+	}
+	a.secrets = nil
+}
+
+// addSecret registers a secret resolved for this connection.
+func (a *sshAuth) addSecret(secrets ...string) {
+	if a.secrets == nil {
+		a.secrets = NewRedactor()
+	}
+	a.secrets.AddLiteral(secrets...)
+}
+
+// redactError hides the secrets resolved for this connection in err.
+func (a *sshAuth) redactError(err error) error {
+	return a.secrets.RedactError(err)
+}
+
+// publicKeys returns the agent and key file signers.
+func (a *sshAuth) publicKeys() ([]ssh.Signer, error) {
+	a.started = true
+
+	return a.signers, nil
+}
+
+// addSigner adds a signer that records its use.
+func (a *sshAuth) addSigner(signer ssh.Signer, method AuthMethod, source string, keyID string) {
+	a.signers = append(a.signers, &trackedSigner{
+		Signer: signer,
+		auth:   a,
+		info:   AuthInfo{Method: method, Source: source, KeyID: keyID},
+	})
+}
+
+// skipKey logs an identity file found by NewRemote() that cannot be
+// used, e.g., because it is corrupt or of an unsupported type.
+func (a *sshAuth) skipKey(filename string, err error) {
+	logAttrs(a.r.Logger, slog.LevelWarn, "ssh private key skipped",
+		append(a.r.logAttrs(),
+			slog.String("key", filename),
+			slog.String("err", err.Error()))...)
+}
+
+// skipCertificate logs a certificate that is not valid and remembers
+// the first one.
+func (a *sshAuth) skipCertificate(err error) {
+	logAttrs(a.r.Logger, slog.LevelWarn, "ssh certificate skipped",
+		append(a.r.logAttrs(), slog.String("err", err.Error()))...)
+	if a.certErr == nil {
+		a.certErr = err
+	}
+}
+
+// agentSigners adds the keys held by the agent. The agent is skipped if
+// $SSH_AUTH_SOCK is not set or cannot be used.
+func (a *sshAuth) agentSigners() {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" || a.agentConn != nil {
+		return
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		logAttrs(a.r.Logger, slog.LevelWarn, "ssh agent unavailable",
+			append(a.r.logAttrs(), slog.String("err", err.Error()))...)
+		return
+	}
+	client := agent.NewClient(conn)
+	keys, err := client.List()
+	if err == nil {
+		var signers []ssh.Signer
+		signers, err = client.Signers()
+		for i, signer := range signers {
+			source := ssh.FingerprintSHA256(signer.PublicKey())
+			if i < len(keys) && keys[i].Comment != "" {
+				source = keys[i].Comment
+			}
+			keyID := ""
+			if cert, ok := asCertificate(signer.PublicKey()); ok {
+				if err := checkCertificate(cert, source, a.r.Credentials.Username, time.Now()); err != nil {
+					a.skipCertificate(err)
+					continue
+				}
+				keyID = cert.KeyId
+			}
+			a.addSigner(signer, AuthAgent, source, keyID)
+		}
+	}
+	if err != nil {
+		conn.Close() // nolint
+		logAttrs(a.r.Logger, slog.LevelWarn, "ssh agent unavailable",
+			append(a.r.logAttrs(), slog.String("err", err.Error()))...)
+		return
+	}
+	a.agentConn = conn
+}
+
+// keySigners adds the private key files, each preceded by its
+// certificate if there is one. Identity files found by NewRemote() are
+// skipped with a warning if they cannot be used.
+// Encrypted keys are decrypted with Credentials.PassphraseCallback when
+// the server accepts their public key if it can be read from the key
+// file, the certificate, or "<key>.pub", otherwise immediately.
+func (a *sshAuth) keySigners() error {
+	creds := a.r.Credentials
+	var filenames []string
+	if creds.PrivateKeyFilename != "" {
+		filenames = append(filenames, creds.PrivateKeyFilename)
+	}
+	filenames = append(filenames, creds.PrivateKeyFilenames...)
+	for i, filename := range filenames {
+		isDefault := a.r.defaultKey
+		certFilename, explicitCert := filename+"-cert.pub", false
+		if i == 0 && creds.CertificateFilename != "" {
+			certFilename, explicitCert = creds.CertificateFilename, true
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if isDefault {
+				a.skipKey(filename, err)
+				continue
+			}
+			return fmt.Errorf("run: could not read private key file '%s': %s", filename, err)
+		}
+		cert, err := readCertificate(certFilename)
+		if err != nil && (explicitCert || !os.IsNotExist(err)) {
+			return fmt.Errorf("run: could not read certificate file '%s': %s", certFilename, err)
+		}
+		signer, err := a.keySigner(filename, data, cert)
+		if err != nil {
+			if isDefault {
+				a.skipKey(filename, err)
+				continue
+			}
+			return err
+		}
+		if cert != nil {
+			if err := a.addCertSigner(signer, cert, certFilename, explicitCert); err != nil {
+				return err
+			}
+		}
+		a.addSigner(signer, AuthPublicKey, filename, "")
+	}
+
+	return nil
+}
+
+// keySigner returns the signer for a private key file.
+func (a *sshAuth) keySigner(filename string, data []byte, cert *ssh.Certificate) (ssh.Signer, error) {
+	creds := a.r.Credentials
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		return signer, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("run: could not use private key file '%s': %s", filename, err)
+	}
+	if creds.PassphraseCallback == nil {
+		return nil, fmt.Errorf("run: could not use private key file '%s': key is encrypted and Credentials.PassphraseCallback is not set", filename)
+	}
+	key := &encryptedKeySigner{
+		filename: filename,
+		data:     data,
+		passphrase: func(filename string) ([]byte, error) {
+			passphrase, err := creds.PassphraseCallback(filename)
+			if err == nil {
+				a.addSecret(string(passphrase))
+			}
+			return passphrase, err
+		},
+	}
+	// Keys in the OpenSSH format include the public key.
+	if cert != nil {
+		key.pub = cert.Key
+	} else if missing.PublicKey != nil {
+		key.pub = missing.PublicKey
+	} else if pub, err := readPublicKey(filename + ".pub"); err == nil {
+		key.pub = pub
+	} else if err := key.decrypt(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// addCertSigner adds a signer presenting cert. A certificate that is
+// not valid is an error if it was given by
+// Credentials.CertificateFilename and is skipped otherwise.
+func (a *sshAuth) addCertSigner(signer ssh.Signer, cert *ssh.Certificate, filename string, explicit bool) error {
+	if err := checkCertificate(cert, filename, a.r.Credentials.Username, time.Now()); err != nil {
+		if explicit {
+			return err
+		}
+		a.skipCertificate(err)
+		return nil
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return fmt.Errorf("run: could not use certificate file '%s': %s", filename, err)
+	}
+	a.addSigner(certSigner, AuthPublicKey, filename, cert.KeyId)
+
+	return nil
+}
+
+// password returns the password and records its use.
+func (a *sshAuth) password() (string, error) {
+	a.started = true
+	a.attempted = AuthInfo{Method: AuthPassword, Source: a.passwordSource()}
+
+	return a.readPassword()
+}
+
+// readPassword returns Credentials.Password or reads
+// Credentials.PasswordSource.
+func (a *sshAuth) readPassword() (string, error) {
+	if a.r.Credentials.Password != "" {
+		return a.r.Credentials.Password, nil
+	}
+	secret, err := a.r.Credentials.PasswordSource.Secret()
+	if err != nil {
+		return "", err
+	}
+	defer ZeroSecret(secret)
+	password := string(secret)
+	a.addSecret(password)
+
+	return password, nil
+}
+
+// passwordSource describes where the password comes from.
+func (a *sshAuth) passwordSource() string {
+	if a.r.Credentials.Password != "" {
+		return "Credentials.Password"
+	}
+
+	return fmt.Sprintf("%T", a.r.Credentials.PasswordSource)
+}
+
+// challenge answers keyboard-interactive challenges with
+// Credentials.KeyboardInteractive or, if it is nil, answers the
+// questions that are not echoed with the password.
+func (a *sshAuth) challenge(user, instruction string, questions []string, echos []bool) ([]string, error) {
+	a.started = true
+	if fn := a.r.Credentials.KeyboardInteractive; fn != nil {
+		a.attempted = AuthInfo{Method: AuthKeyboardInteractive, Source: "Credentials.KeyboardInteractive"}
+		answers, err := fn(user, instruction, questions, echos)
+		for i := range answers {
+			if i < len(echos) && !echos[i] {
+				a.addSecret(answers[i])
+			}
+		}
+		return answers, err
+	}
+	a.attempted = AuthInfo{Method: AuthKeyboardInteractive, Source: a.passwordSource()}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		if echos[i] {
+			return nil, fmt.Errorf("run: cannot answer keyboard-interactive question %q without Credentials.KeyboardInteractive", question)
+		}
+		password, err := a.readPassword()
+		if err != nil {
+			return nil, err
+		}
+		answers[i] = password
+	}
+
+	return answers, nil
+}
+
+// trackedSigner records in auth that its key is being used.
+type trackedSigner struct {
+	ssh.Signer
+	auth *sshAuth
+	info AuthInfo
+}
+
+// Sign records the key and signs data.
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.auth.attempted = s.info
+
+	return s.Signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm records the key and signs data using algorithm,
+// so RSA keys can sign with SHA-2, e.g., "rsa-sha2-256".
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.auth.attempted = s.info
+
+	return signWithAlgorithm(s.Signer, rand, data, algorithm)
+}
+
+// signWithAlgorithm signs data with signer using algorithm. An empty
+// algorithm uses the default algorithm of the key.
+func signWithAlgorithm(signer ssh.Signer, rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if as, ok := signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	if algorithm != "" && algorithm != signer.PublicKey().Type() {
+		return nil, fmt.Errorf("run: %s key cannot sign with %s", signer.PublicKey().Type(), algorithm)
+	}
+
+	return signer.Sign(rand, data)
+}
+
+// encryptedKeySigner is a signer for a passphrase protected private
+// key file that is decrypted when it is first needed.
+type encryptedKeySigner struct {
+	filename   string
+	data       []byte
+	pub        ssh.PublicKey
+	passphrase PassphraseFunc
+	signer     ssh.Signer
+}
+
+// decrypt decrypts the key.
+func (k *encryptedKeySigner) decrypt() error {
+	passphrase, err := k.passphrase(k.filename)
+	if err != nil {
+		return fmt.Errorf("run: could not get passphrase for private key file '%s': %w", k.filename, err)
+	}
+	defer ZeroSecret(passphrase)
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(k.data, passphrase)
+	if err != nil {
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return fmt.Errorf("run: could not decrypt private key file '%s': incorrect passphrase", k.filename)
+		}
+		return fmt.Errorf("run: could not use private key file '%s': %s", k.filename, err)
+	}
+	k.signer = signer
+	k.pub = signer.PublicKey()
+	k.data = nil
+
+	return nil
+}
+
+// PublicKey returns the public key.
+func (k *encryptedKeySigner) PublicKey() ssh.PublicKey {
+	return k.pub
+}
+
+// Sign decrypts the key if needed and signs data.
+func (k *encryptedKeySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	if k.signer == nil {
+		if err := k.decrypt(); err != nil {
+			return nil, err
+		}
+	}
+
+	return k.signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm decrypts the key if needed and signs data using
+// algorithm.
+func (k *encryptedKeySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if k.signer == nil {
+		if err := k.decrypt(); err != nil {
+			return nil, err
+		}
+	}
+
+	return signWithAlgorithm(k.signer, rand, data, algorithm)
+}
+
+// readPublicKey reads a public key file in authorized_keys format.
+func readPublicKey(filename string) (ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+
+	return pub, err
+}
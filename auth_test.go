@@ -0,0 +1,449 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authServerConfig returns a server configuration accepting the
+// password, the public keys, and keyboard-interactive answers equal to
+// the password.
+func authServerConfig(password string, keys ...ssh.PublicKey) *ssh.ServerConfig {
+	return &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if password != "" && string(p) == password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range keys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown key")
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if password != "" && len(answers) == 1 && answers[0] == password {
+				return nil, nil
+			}
+			return nil, errors.New("wrong answer")
+		},
+	}
+}
+
+// noAgent unsets SSH_AUTH_SOCK and returns a function restoring it.
+func noAgent() func() {
+	sock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Unsetenv("SSH_AUTH_SOCK")
+
+	return func() {
+		if ok {
+			os.Setenv("SSH_AUTH_SOCK", sock)
+		}
+	}
+}
+
+func TestRemote_AuthMethods(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	unknownKey, _ := writeTestKey(t, dir, "unknown", "")
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "")
+	server := newTestSSHServer(t, authServerConfig("s3cret", pub))
+	defer setenv(t, map[string]string{"TEST_AUTH_PASSWORD": "s3cret"})()
+
+	for _, tc := range []struct {
+		name   string
+		creds  func(*run.Credentials)
+		method run.AuthMethod
+		source string
+	}{
+		{"password", func(c *run.Credentials) {
+			c.Password = "s3cret"
+		}, run.AuthPassword, "Credentials.Password"},
+		{"key", func(c *run.Credentials) {
+			c.PrivateKeyFilename = key
+			c.Password = "s3cret"
+		}, run.AuthPublicKey, key},
+		{"second key", func(c *run.Credentials) {
+			c.PrivateKeyFilename = unknownKey
+			c.PrivateKeyFilenames = []string{key}
+		}, run.AuthPublicKey, key},
+		{"order", func(c *run.Credentials) {
+			c.PrivateKeyFilename = key
+			c.Password = "s3cret"
+			c.AuthMethods = []run.AuthMethod{run.AuthPassword, run.AuthPublicKey}
+		}, run.AuthPassword, "Credentials.Password"},
+		{"fallback", func(c *run.Credentials) {
+			c.PrivateKeyFilename = unknownKey
+			c.PasswordSource = run.EnvSecret("TEST_AUTH_PASSWORD")
+		}, run.AuthPassword, "run.EnvSecret"},
+		{"keyboard-interactive", func(c *run.Credentials) {
+			c.Password = "s3cret"
+			c.AuthMethods = []run.AuthMethod{run.AuthKeyboardInteractive}
+		}, run.AuthKeyboardInteractive, "Credentials.Password"},
+		{"challenge", func(c *run.Credentials) {
+			c.AuthMethods = []run.AuthMethod{run.AuthPublicKey, run.AuthKeyboardInteractive}
+			c.PrivateKeyFilename = unknownKey
+			c.KeyboardInteractive = func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return []string{"s3cret"}, nil
+			}
+		}, run.AuthKeyboardInteractive, "Credentials.KeyboardInteractive"},
+	} {
+		creds := server.Credentials("deploy")
+		tc.creds(&creds)
+		r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+		require.NoError(t, err, tc.name)
+		stdout, _, code, err := r.Run("echo", "hello")
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, "hello\n", stdout, tc.name)
+		assert.Zero(t, code, tc.name)
+		auth := r.LastAuth()
+		t.Logf("%s: auth = %s", tc.name, auth)
+		assert.Equal(t, tc.method, auth.Method, tc.name)
+		assert.Equal(t, tc.source, auth.Source, tc.name)
+	}
+}
+
+func TestRemote_EncryptedKey(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "k3y-s3cret")
+	otherKey, otherPub := writeTestKey(t, dir, "other", "other passphrase")
+	writeTestPublicKey(t, otherKey+".pub", otherPub)
+	server := newTestSSHServer(t, authServerConfig("", pub))
+
+	var asked []string
+	creds := server.Credentials("deploy")
+	creds.PrivateKeyFilename = otherKey
+	creds.PrivateKeyFilenames = []string{key}
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		asked = append(asked, filename)
+		return []byte("k3y-s3cret"), nil
+	}
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, code, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: key}, r.LastAuth())
+
+	// The key with a public key file is not decrypted since the
+	// server does not accept it.
+	assert.Equal(t, []string{key}, asked)
+
+	// Wrong passphrase.
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		return []byte("wrong"), nil
+	}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	// No passphrase callback.
+	creds.PassphraseCallback = nil
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
+
+func TestRemote_OpenSSHKeys(t *testing.T) {
+	defer noAgent()()
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, pub := writeTestOpenSSHKey(t, dir, "id_ed25519", edKey, "ed-s3cret")
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	other, _ := writeTestOpenSSHKey(t, dir, "other", otherKey, "other passphrase")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaFile, rsaPub := writeTestOpenSSHKey(t, dir, "id_rsa", rsaKey, "rsa-s3cret")
+
+	// Servers that refuse SHA-1 signatures, like OpenSSH 8.8 and
+	// later, accept RSA keys.
+	config := authServerConfig("", pub, rsaPub)
+	config.PublicKeyAuthAlgorithms = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512}
+	server := newTestSSHServer(t, config)
+
+	// Encrypted keys are only decrypted if the server accepts
+	// their public key, which is read from the key file.
+	var asked []string
+	creds := server.Credentials("deploy")
+	creds.PrivateKeyFilename = other
+	creds.PrivateKeyFilenames = []string{key}
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		asked = append(asked, filename)
+		return []byte("ed-s3cret"), nil
+	}
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: key}, r.LastAuth())
+	assert.Equal(t, []string{key}, asked)
+
+	// Wrong passphrase.
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		return []byte("wrong"), nil
+	}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "incorrect passphrase")
+
+	// RSA keys.
+	creds = server.Credentials("deploy")
+	creds.PrivateKeyFilename = rsaFile
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		return []byte("rsa-s3cret"), nil
+	}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: rsaFile}, r.LastAuth())
+}
+
+func TestRemote_SecretsNotRegistered(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "key-passphrase")
+	server := newTestSSHServer(t, authServerConfig("source-password", pub))
+
+	// A password read from a source does not change DefaultRedactor.
+	creds := server.Credentials("deploy")
+	creds.PasswordSource = run.SecretFunc(func() ([]byte, error) {
+		return []byte("source-password"), nil
+	})
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, "source-password", run.DefaultRedactor.Redact("source-password"))
+	assert.Equal(t, "ssh deploy@127.0.0.1 echo source-password", r.FormatRun("echo", "source-password"))
+
+	// Nor does a key passphrase change the given Redactor.
+	redactor := run.NewRedactor()
+	creds = server.Credentials("deploy")
+	creds.PrivateKeyFilename = key
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		return []byte("key-passphrase"), nil
+	}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds, Redactor: redactor})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, "key-passphrase", redactor.Redact("key-passphrase"))
+	assert.Equal(t, "key-passphrase", run.DefaultRedactor.Redact("key-passphrase"))
+}
+
+func TestRemote_SecretsRedactedInConnectErrors(t *testing.T) {
+	defer noAgent()()
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	creds := server.Credentials("deploy")
+	creds.AuthMethods = []run.AuthMethod{run.AuthKeyboardInteractive}
+	creds.KeyboardInteractive = func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		return []string{"otp-428913"}, errors.New("token otp-428913 was rejected")
+	}
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "otp-428913")
+	assert.Contains(t, err.Error(), "token ****** was rejected")
+	var connErr *run.ConnectionError
+	assert.True(t, errors.As(err, &connErr))
+	assert.Equal(t, "otp-428913", run.DefaultRedactor.Redact("otp-428913"))
+}
+
+func TestRemote_AgentAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "")
+	data, err := ioutil.ReadFile(key)
+	require.NoError(t, err)
+	rawKey, err := ssh.ParseRawPrivateKey(data)
+	require.NoError(t, err)
+
+	sock := startTestAgent(t, dir, agent.AddedKey{PrivateKey: rawKey, Comment: "deploy@lab"})
+	defer setenv(t, map[string]string{"SSH_AUTH_SOCK": sock})()
+
+	server := newTestSSHServer(t, authServerConfig("s3cret", pub))
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, code, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthAgent, Source: "deploy@lab"}, r.LastAuth())
+
+	// An unusable agent is skipped.
+	os.Setenv("SSH_AUTH_SOCK", filepath.Join(dir, "missing.sock"))
+	_, _, code, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthPassword, r.LastAuth().Method)
+}
+
+func TestRemote_AuthErrors(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+
+	for name, creds := range map[string]run.Credentials{
+		"wrong password": {Password: "wrong"},
+		"missing key":    {PrivateKeyFilename: filepath.Join(dir, "missing")},
+		"no methods":     {Password: "s3cret", AuthMethods: []run.AuthMethod{run.AuthAgent}},
+		"unknown method": {Password: "s3cret", AuthMethods: []run.AuthMethod{"gssapi-with-mic"}},
+	} {
+		c := server.Credentials("deploy")
+		c.Password = creds.Password
+		c.PrivateKeyFilename = creds.PrivateKeyFilename
+		c.AuthMethods = creds.AuthMethods
+		r, err := run.NewRemote(run.RemoteConfig{Credentials: c})
+		require.NoError(t, err, name)
+		_, _, _, err = r.Run("/bin/true")
+		t.Logf("%s: err = %v", name, err)
+		assert.Error(t, err, name)
+		assert.NotContains(t, fmt.Sprint(err), "s3cret", name)
+		assert.Equal(t, run.AuthInfo{}, r.LastAuth(), name)
+	}
+}
+
+func TestRemote_DefaultKeys(t *testing.T) {
+	defer noAgent()()
+	home, err := ioutil.TempDir("", "home")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	defer setenv(t, map[string]string{"HOME": home})()
+	sshDir := filepath.Join(home, ".ssh")
+	require.NoError(t, os.Mkdir(sshDir, 0700))
+
+	// No identity files.
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: run.Credentials{Username: "deploy"}})
+	require.NoError(t, err)
+	assert.Empty(t, r.Credentials.PrivateKeyFilename)
+	assert.Empty(t, r.Credentials.PrivateKeyFilenames)
+
+	// The identity files that exist are tried in order and the ones
+	// that cannot be used are skipped with a warning.
+	ecdsaKey, _ := writeTestKey(t, sshDir, "id_ecdsa", "")
+	rsaKey, pub := writeTestKey(t, sshDir, "id_rsa", "")
+	dsaKey := filepath.Join(sshDir, "id_dsa")
+	require.NoError(t, ioutil.WriteFile(dsaKey, []byte("not a key\n"), 0600))
+	server := newTestSSHServer(t, authServerConfig("", pub))
+	var b bytes.Buffer
+	r, err = run.NewRemote(run.RemoteConfig{
+		Credentials: server.Credentials("deploy"),
+		Logger:      slog.New(slog.NewJSONHandler(&b, nil)),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ecdsaKey, r.Credentials.PrivateKeyFilename)
+	assert.Equal(t, []string{rsaKey, dsaKey}, r.Credentials.PrivateKeyFilenames)
+	_, _, code, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: rsaKey}, r.LastAuth())
+	t.Logf("log = %s", b.String())
+	assert.Contains(t, b.String(), `"level":"WARN","msg":"ssh private key skipped"`)
+	assert.Contains(t, b.String(), dsaKey)
+
+	// ECDSA keys in the OpenSSH format, which ssh-keygen writes by
+	// default, are used.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, pub = writeTestOpenSSHKey(t, sshDir, "id_ecdsa", key, "")
+	server = newTestSSHServer(t, authServerConfig("", pub))
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: server.Credentials("deploy")})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: ecdsaKey}, r.LastAuth())
+}
+
+func TestRemote_AgentForwarding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, _ := writeTestKey(t, dir, "id_ecdsa", "")
+	data, err := ioutil.ReadFile(key)
+	require.NoError(t, err)
+	rawKey, err := ssh.ParseRawPrivateKey(data)
+	require.NoError(t, err)
+	sock := startTestAgent(t, dir, agent.AddedKey{PrivateKey: rawKey, Comment: "deploy@lab"})
+	defer setenv(t, map[string]string{"SSH_AUTH_SOCK": sock})()
+
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds, AgentForwarding: true})
+	require.NoError(t, err)
+	assert.True(t, r.AgentForwarding)
+	_, _, code, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, []string{"deploy@lab"}, server.AgentKeys())
+
+	// The agent is not forwarded by default.
+	r.AgentForwarding = false
+	_, _, _, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Len(t, server.AgentKeys(), 1)
+
+	// Refused by the server.
+	server.RefuseAgentForwarding = true
+	r.AgentForwarding = true
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	// No agent.
+	os.Unsetenv("SSH_AUTH_SOCK")
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
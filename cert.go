@@ -0,0 +1,123 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Reasons an SSH user certificate cannot be used. They are wrapped in a
+// CertificateError.
+var (
+	ErrCertificateExpired     = errors.New("certificate has expired")
+	ErrCertificateNotYetValid = errors.New("certificate is not yet valid")
+	ErrCertificatePrincipal   = errors.New("user is not a principal of the certificate")
+)
+
+// CertificateError reports an SSH user certificate that cannot be
+// used to authenticate.
+type CertificateError struct {
+	// Source is the certificate file or the comment of the agent
+	// key.
+	Source string
+
+	// KeyID is the key ID of the certificate.
+	KeyID string
+
+	// Err describes the problem. It wraps ErrCertificateExpired,
+	// ErrCertificateNotYetValid, or ErrCertificatePrincipal if the
+	// certificate is not valid for the connection.
+	Err error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("run: SSH certificate %s (key ID %q): %v", e.Source, e.KeyID, e.Err)
+}
+
+func (e *CertificateError) Unwrap() error {
+	return e.Err
+}
+
+// checkCertificate returns an error if cert is not a user certificate
+// valid for username at now.
+func checkCertificate(cert *ssh.Certificate, source string, username string, now time.Time) error {
+	var err error
+	unix := uint64(now.Unix())
+	switch {
+	case cert.CertType != ssh.UserCert:
+		err = errors.New("not a user certificate")
+	case unix < cert.ValidAfter:
+		err = fmt.Errorf("%w: valid from %s", ErrCertificateNotYetValid, certTime(cert.ValidAfter))
+	case cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore:
+		err = fmt.Errorf("%w: valid until %s", ErrCertificateExpired, certTime(cert.ValidBefore))
+	case len(cert.ValidPrincipals) > 0 && !contains(cert.ValidPrincipals, username):
+		err = fmt.Errorf("%w: user %q, principals %s",
+			ErrCertificatePrincipal, username, strings.Join(cert.ValidPrincipals, ","))
+	}
+	if err != nil {
+		return &CertificateError{Source: source, KeyID: cert.KeyId, Err: err}
+	}
+
+	return nil
+}
+
+// certTime formats a certificate validity time.
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+// contains returns true if list contains s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// asCertificate returns pub as a certificate if it is one.
+func asCertificate(pub ssh.PublicKey) (*ssh.Certificate, bool) {
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		return cert, true
+	}
+	if !strings.Contains(pub.Type(), "-cert-") {
+		return nil, false
+	}
+	// Agent keys are not parsed.
+	parsed, err := ssh.ParsePublicKey(pub.Marshal())
+	if err != nil {
+		return nil, false
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+
+	return cert, ok
+}
+
+// readCertificate reads a certificate file in authorized_keys format,
+// e.g., "id_ed25519-cert.pub".
+func readCertificate(filename string) (*ssh.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %s public key, not a certificate", filename, pub.Type())
+	}
+
+	return cert, nil
+}
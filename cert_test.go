@@ -0,0 +1,163 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// newTestCA returns a user certificate authority and a server
+// configuration trusting it.
+func newTestCA(t *testing.T) (ssh.Signer, *ssh.ServerConfig) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+
+	return ca, &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+}
+
+// signTestCert returns a user certificate for pub valid for principals
+// until validBefore.
+func signTestCert(t *testing.T, ca ssh.Signer, pub ssh.PublicKey, keyID string, principals []string, validBefore time.Time) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validBefore.Add(-24 * time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	return cert
+}
+
+func TestRemote_Certificate(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca, config := newTestCA(t)
+	server := newTestSSHServer(t, config)
+	valid := time.Now().Add(time.Hour)
+
+	// The certificate next to the key is found.
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "")
+	writeTestPublicKey(t, key+"-cert.pub", signTestCert(t, ca, pub, "deploy-1", []string{"deploy"}, valid))
+	creds := server.Credentials("deploy")
+	creds.PrivateKeyFilename = key
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, code, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: key + "-cert.pub", KeyID: "deploy-1"}, r.LastAuth())
+
+	// An explicit certificate for an encrypted key.
+	encKey, encPub := writeTestKey(t, dir, "encrypted", "c3rt-s3cret")
+	certFile := filepath.Join(dir, "deploy.cert")
+	writeTestPublicKey(t, certFile, signTestCert(t, ca, encPub, "deploy-2", nil, valid))
+	creds.PrivateKeyFilename = encKey
+	creds.CertificateFilename = certFile
+	creds.PassphraseCallback = func(filename string) ([]byte, error) {
+		return []byte("c3rt-s3cret"), nil
+	}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, code, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthInfo{Method: run.AuthPublicKey, Source: certFile, KeyID: "deploy-2"}, r.LastAuth())
+
+	// An agent certificate.
+	data, err := ioutil.ReadFile(key)
+	require.NoError(t, err)
+	rawKey, err := ssh.ParseRawPrivateKey(data)
+	require.NoError(t, err)
+	sock := startTestAgent(t, dir, agent.AddedKey{
+		PrivateKey:  rawKey,
+		Certificate: signTestCert(t, ca, pub, "deploy-3", []string{"deploy"}, valid),
+		Comment:     "deploy@lab",
+	})
+	defer setenv(t, map[string]string{"SSH_AUTH_SOCK": sock})()
+	creds = server.Credentials("deploy")
+	creds.PrivateKeyFilename = filepath.Join(dir, "missing")
+	creds.AuthMethods = []run.AuthMethod{run.AuthAgent}
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, code, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, run.AuthAgent, r.LastAuth().Method)
+	assert.Equal(t, "deploy-3", r.LastAuth().KeyID)
+}
+
+func TestRemote_CertificateErrors(t *testing.T) {
+	defer noAgent()()
+	dir, err := ioutil.TempDir("", "cert")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca, config := newTestCA(t)
+	server := newTestSSHServer(t, config)
+	key, pub := writeTestKey(t, dir, "id_ecdsa", "")
+
+	// An expired explicit certificate is an error before connecting.
+	certFile := filepath.Join(dir, "expired.cert")
+	writeTestPublicKey(t, certFile, signTestCert(t, ca, pub, "expired", []string{"deploy"}, time.Now().Add(-time.Hour)))
+	creds := server.Credentials("deploy")
+	creds.PrivateKeyFilename = key
+	creds.CertificateFilename = certFile
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	var cerr *run.CertificateError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, certFile, cerr.Source)
+	assert.Equal(t, "expired", cerr.KeyID)
+	assert.True(t, errors.Is(err, run.ErrCertificateExpired))
+
+	// A certificate for other principals is skipped and reported
+	// when authentication fails.
+	writeTestPublicKey(t, key+"-cert.pub", signTestCert(t, ca, pub, "admin", []string{"admin"}, time.Now().Add(time.Hour)))
+	creds.CertificateFilename = ""
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.True(t, errors.Is(err, run.ErrCertificatePrincipal))
+
+	// A certificate for another key.
+	otherKey, _ := writeTestKey(t, dir, "other", "")
+	creds.PrivateKeyFilename = otherKey
+	creds.CertificateFilename = key + "-cert.pub"
+	creds.Username = "admin"
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
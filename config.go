@@ -0,0 +1,492 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables that override
+// configuration file settings.
+const EnvPrefix = "GORUN_"
+
+// Configuration file fields. Each can be overridden by the environment
+// variable named EnvPrefix followed by the field name in upper case,
+// e.g., GORUN_PORT.
+const (
+	configURL            = "url"
+	configShell          = "shell"
+	configDir            = "dir"
+	configEnv            = "env"
+	configHost           = "host"
+	configPort           = "port"
+	configUser           = "user"
+	configPassword       = "password"
+	configKey            = "key"
+	configLogOutputBytes = "log_output_bytes"
+)
+
+var (
+	// configFields lists the configuration file fields in the
+	// order environment variables are checked.
+	configFields = []string{
+		configURL, configShell, configDir, configEnv, configHost, configPort,
+		configUser, configPassword, configKey, configLogOutputBytes,
+	}
+
+	// localFields and remoteFields list the fields that apply to
+	// LocalConfig and RemoteConfig.
+	localFields  = []string{configShell, configDir, configEnv, configLogOutputBytes}
+	remoteFields = []string{configShell, configHost, configPort, configUser, configPassword, configKey, configLogOutputBytes}
+)
+
+// fileConfig holds the settings read from a configuration file and
+// the environment.
+type fileConfig struct {
+	// set records which fields were given.
+	set map[string]bool
+
+	// dir is the directory relative file names are resolved
+	// against.
+	dir string
+
+	url            string
+	shell          string
+	workDir        string
+	env            []string
+	host           string
+	port           int
+	user           string
+	password       string
+	key            string
+	logOutputBytes int
+}
+
+// ConfigError describes an invalid configuration setting.
+type ConfigError struct {
+	// Source is the configuration file name or "environment".
+	Source string
+
+	// Field is the name of the offending field, e.g., "port" in
+	// a file or "GORUN_PORT" in the environment.
+	Field string
+
+	// Err describes what is wrong with the field.
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("run: config %s: %s", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("run: config %s: %s: %s", e.Source, e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// LoadLocalConfig returns a LocalConfig read from a configuration file
+// and overridden by GORUN_* environment variables. The file is read
+// as YAML, JSON, or TOML depending on its extension (".yaml" or
+// ".yml", ".json", or ".toml"). If filename is empty, only the
+// environment is used. The fields are:
+//
+//     shell             LocalConfig.ShellExecutable
+//     dir               LocalConfig.Dir
+//     env               LocalConfig.Env, a list of "NAME=value" strings
+//     log_output_bytes  LocalConfig.LogOutputBytes
+//
+// Each field is overridden by the environment variable named GORUN_
+// followed by the field name in upper case, e.g., GORUN_DIR. GORUN_ENV
+// holds comma-separated entries. Errors are of type *ConfigError and
+// name the offending field.
+func LoadLocalConfig(filename string) (LocalConfig, error) {
+	fc, err := loadFileConfig(filename)
+	if err != nil {
+		return LocalConfig{}, err
+	}
+	if err := fc.check(filename, "local", localFields); err != nil {
+		return LocalConfig{}, err
+	}
+	var config LocalConfig
+	fc.applyLocal(&config)
+
+	return config, nil
+}
+
+// LoadRemoteConfig returns a RemoteConfig read from a configuration
+// file and overridden by GORUN_* environment variables as described
+// for LoadLocalConfig(). The fields are:
+//
+//     shell             RemoteConfig.ShellExecutable
+//     host              Credentials.Hostname
+//     port              Credentials.Port
+//     user              Credentials.Username
+//     password          Credentials.PasswordSource, as a secret reference
+//     key               Credentials.PrivateKeyFilename
+//     log_output_bytes  RemoteConfig.LogOutputBytes
+//
+// Secrets are not stored in the file. The password field is a
+// reference to the secret as accepted by ParseSecretRef(), e.g.,
+// "env:NAME", "file:/path", or "cmd:pass show deploy", that is read
+// when a connection is made. GORUN_PASSWORD is also a reference.
+// Relative file names are relative to the directory of the
+// configuration file.
+func LoadRemoteConfig(filename string) (RemoteConfig, error) {
+	fc, err := loadFileConfig(filename)
+	if err != nil {
+		return RemoteConfig{}, err
+	}
+	if err := fc.check(filename, "remote", remoteFields); err != nil {
+		return RemoteConfig{}, err
+	}
+	var config RemoteConfig
+	if err := fc.applyRemote(filename, &config); err != nil {
+		return RemoteConfig{}, err
+	}
+
+	return config, nil
+}
+
+// OpenFile returns a Runner described by a configuration file and the
+// GORUN_* environment variables. The url field, if set, is passed to
+// Open(). Otherwise an "ssh" URL is used if the host field is set and
+// a "local" URL if it is not. The other fields, described for
+// LoadLocalConfig() and LoadRemoteConfig(), override the
+// configuration set by opts and are in turn overridden by settings in
+// the URL.
+func OpenFile(filename string, opts ...OpenOption) (Runner, error) {
+	fc, err := loadFileConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	rawurl := fc.url
+	if rawurl == "" && fc.host != "" {
+		host := fc.host
+		if strings.Contains(host, ":") {
+			// IPv6 address.
+			host = "[" + host + "]"
+		}
+		rawurl = "ssh://" + host
+	} else if rawurl == "" {
+		rawurl = "local://"
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fc.error(filename, configURL, redactURLError(err))
+	}
+
+	var config OpenConfig
+	for _, opt := range opts {
+		opt(&config)
+	}
+	kind, fields := "local", localFields
+	if strings.ToLower(u.Scheme) != "local" {
+		kind, fields = "remote", remoteFields
+	}
+	if err := fc.check(filename, kind, append([]string{configURL}, fields...)); err != nil {
+		return nil, err
+	}
+	fc.applyLocal(&config.Local)
+	if err := fc.applyRemote(filename, &config.Remote); err != nil {
+		return nil, err
+	}
+
+	return Open(rawurl, WithLocalConfig(config.Local), WithRemoteConfig(config.Remote))
+}
+
+// loadFileConfig reads a configuration file, if filename is not
+// empty, and applies the environment overrides.
+func loadFileConfig(filename string) (*fileConfig, error) {
+	fc := &fileConfig{set: make(map[string]bool)}
+	if filename != "" {
+		fields, err := readConfigFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		fc.dir = filepath.Dir(filename)
+		var names []string
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := fc.setField(name, fields[name]); err != nil {
+				return nil, &ConfigError{Source: filename, Field: name, Err: err}
+			}
+		}
+	}
+
+	for _, name := range configFields {
+		env := EnvPrefix + strings.ToUpper(name)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		var v interface{} = value
+		if name == configEnv {
+			var list []interface{}
+			for _, entry := range strings.Split(value, ",") {
+				list = append(list, entry)
+			}
+			v = list
+		}
+		if err := fc.setField(name, v); err != nil {
+			return nil, &ConfigError{Source: "environment", Field: env, Err: err}
+		}
+	}
+
+	return fc, nil
+}
+
+// readConfigFile decodes a YAML, JSON, or TOML configuration file.
+func readConfigFile(filename string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("run: config: %w", err)
+	}
+	fields := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		var m map[interface{}]interface{}
+		err = yaml.Unmarshal(data, &m)
+		for k, v := range m {
+			fields[fmt.Sprint(k)] = v
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&fields)
+	case ".toml":
+		_, err = toml.Decode(string(data), &fields)
+	default:
+		return nil, &ConfigError{Source: filename,
+			Err: fmt.Errorf("unknown file type %q, expected .yaml, .yml, .json, or .toml", filepath.Ext(filename))}
+	}
+	if err != nil {
+		return nil, &ConfigError{Source: filename, Err: err}
+	}
+
+	return fields, nil
+}
+
+// setField sets a field from a decoded value.
+func (fc *fileConfig) setField(name string, v interface{}) error {
+	var err error
+	switch name {
+	case configURL:
+		fc.url, err = configString(v)
+	case configShell:
+		fc.shell, err = configString(v)
+	case configDir:
+		fc.workDir, err = configString(v)
+	case configEnv:
+		fc.env, err = configStrings(v)
+		for _, entry := range fc.env {
+			if err == nil && !strings.Contains(entry, "=") {
+				err = fmt.Errorf("expected NAME=value, found %q", entry)
+			}
+		}
+	case configHost:
+		fc.host, err = configString(v)
+	case configPort:
+		fc.port, err = configInt(v)
+		if err == nil && (fc.port < 1 || fc.port > 65535) {
+			err = fmt.Errorf("must be between 1 and 65535, found %d", fc.port)
+		}
+	case configUser:
+		fc.user, err = configString(v)
+	case configPassword:
+		fc.password, err = configString(v)
+		if err == nil {
+			_, err = ParseSecretRef(fc.password)
+		}
+		if err != nil {
+			err = fmt.Errorf("must be a secret reference such as env:NAME, file:/path, or cmd:command, not the secret itself")
+		}
+	case configKey:
+		fc.key, err = configString(v)
+	case configLogOutputBytes:
+		fc.logOutputBytes, err = configInt(v)
+		if err == nil && fc.logOutputBytes < 0 {
+			err = fmt.Errorf("must not be negative, found %d", fc.logOutputBytes)
+		}
+	default:
+		return fmt.Errorf("unknown field, expected one of %s", strings.Join(configFields, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	fc.set[name] = true
+
+	return nil
+}
+
+// check returns an error if a field that is not in allowed was set.
+// kind describes the configuration being loaded.
+func (fc *fileConfig) check(filename string, kind string, allowed []string) error {
+	for _, name := range configFields {
+		if !fc.set[name] {
+			continue
+		}
+		found := false
+		for _, a := range allowed {
+			found = found || a == name
+		}
+		if !found {
+			return fc.error(filename, name, fmt.Errorf("not valid for a %s configuration", kind))
+		}
+	}
+
+	return nil
+}
+
+// error returns a ConfigError for a field. The source is the
+// environment if the field's environment variable is set.
+func (fc *fileConfig) error(filename string, name string, err error) error {
+	env := EnvPrefix + strings.ToUpper(name)
+	if _, ok := os.LookupEnv(env); ok || filename == "" {
+		return &ConfigError{Source: "environment", Field: env, Err: err}
+	}
+
+	return &ConfigError{Source: filename, Field: name, Err: err}
+}
+
+func (fc *fileConfig) applyLocal(config *LocalConfig) {
+	if fc.set[configShell] {
+		config.ShellExecutable = fc.shell
+	}
+	if fc.set[configDir] {
+		config.Dir = fc.resolvePath(fc.workDir)
+	}
+	if fc.set[configEnv] {
+		config.Env = fc.env
+	}
+	if fc.set[configLogOutputBytes] {
+		config.LogOutputBytes = fc.logOutputBytes
+	}
+}
+
+func (fc *fileConfig) applyRemote(filename string, config *RemoteConfig) error {
+	if fc.set[configShell] {
+		config.ShellExecutable = fc.shell
+	}
+	if fc.set[configHost] {
+		config.Credentials.Hostname = fc.host
+	}
+	if fc.set[configPort] {
+		config.Credentials.Port = fc.port
+	}
+	if fc.set[configUser] {
+		config.Credentials.Username = fc.user
+	}
+	if fc.set[configPassword] {
+		source, err := ParseSecretRef(fc.password)
+		if err != nil {
+			return fc.error(filename, configPassword, err)
+		}
+		if f, ok := source.(FileSecret); ok {
+			source = FileSecret(fc.resolvePath(string(f)))
+		}
+		config.Credentials.Password = ""
+		config.Credentials.PasswordSource = source
+	}
+	if fc.set[configKey] {
+		config.Credentials.PrivateKeyFilename = fc.resolvePath(fc.key)
+	}
+	if fc.set[configLogOutputBytes] {
+		config.LogOutputBytes = fc.logOutputBytes
+	}
+
+	return nil
+}
+
+// resolvePath expands a leading "~/" to the home directory and makes
+// relative paths relative to the configuration file's directory.
+func (fc *fileConfig) resolvePath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if fc.dir != "" && path != "" && !filepath.IsAbs(path) {
+		return filepath.Join(fc.dir, path)
+	}
+
+	return path
+}
+
+// configString converts a decoded value to a string.
+func configString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, found %v", v)
+	}
+
+	return s, nil
+}
+
+// configStrings converts a decoded list to strings.
+func configStrings(v interface{}) ([]string, error) {
+	var list []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			s, err := configString(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, s)
+		}
+	default:
+		return nil, fmt.Errorf("expected a list of strings, found %v", v)
+	}
+
+	return list, nil
+}
+
+// configInt converts a decoded value, or a string from the
+// environment, to an int.
+func configInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int64:
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return 0, fmt.Errorf("%d is out of range", v)
+		}
+		return int(v), nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+			return 0, fmt.Errorf("expected an integer, found %v", v)
+		}
+		return int(v), nil
+	case json.Number:
+		return configInt(string(v))
+	case string:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, found %q", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, found %v", v)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes a configuration file to dir.
+func writeConfig(t *testing.T, dir string, name string, data string) string {
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(data), 0600))
+
+	return filename
+}
+
+// setenv sets environment variables and returns a function restoring
+// them.
+func setenv(t *testing.T, vars map[string]string) func() {
+	old := make(map[string]*string)
+	for name, value := range vars {
+		if v, ok := os.LookupEnv(name); ok {
+			old[name] = &v
+		} else {
+			old[name] = nil
+		}
+		require.NoError(t, os.Setenv(name, value))
+	}
+
+	return func() {
+		for name, value := range old {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+func TestLoadRemoteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeConfig(t, dir, "password", "s3cret\n")
+	defer setenv(t, map[string]string{"TEST_DEPLOY_PASSWORD": "env-s3cret"})()
+
+	for name, data := range map[string]string{
+		"remote.yaml": `
+host: web01.lab
+port: 2222
+user: deploy
+password: file:password
+key: keys/deploy
+shell: /bin/bash
+log_output_bytes: 100
+`,
+		"remote.json": `{
+    "host": "web01.lab",
+    "port": 2222,
+    "user": "deploy",
+    "password": "file:password",
+    "key": "keys/deploy",
+    "shell": "/bin/bash",
+    "log_output_bytes": 100
+}`,
+		"remote.toml": `
+host = "web01.lab"
+port = 2222
+user = "deploy"
+password = "file:password"
+key = "keys/deploy"
+shell = "/bin/bash"
+log_output_bytes = 100
+`,
+	} {
+		filename := writeConfig(t, dir, name, data)
+		config, err := run.LoadRemoteConfig(filename)
+		require.NoError(t, err, name)
+		assert.Equal(t, "web01.lab", config.Credentials.Hostname, name)
+		assert.Equal(t, 2222, config.Credentials.Port, name)
+		assert.Equal(t, "deploy", config.Credentials.Username, name)
+		assert.Empty(t, config.Credentials.Password, name)
+		assert.Equal(t, run.FileSecret(filepath.Join(dir, "password")), config.Credentials.PasswordSource, name)
+		assert.Equal(t, filepath.Join(dir, "keys/deploy"), config.Credentials.PrivateKeyFilename, name)
+		assert.Equal(t, "/bin/bash", config.ShellExecutable, name)
+		assert.Equal(t, 100, config.LogOutputBytes, name)
+	}
+
+	// Environment overrides.
+	filename := filepath.Join(dir, "remote.yaml")
+	defer setenv(t, map[string]string{
+		"GORUN_HOST":     "web02.lab",
+		"GORUN_PORT":     "22",
+		"GORUN_PASSWORD": "env:TEST_DEPLOY_PASSWORD",
+	})()
+	config, err := run.LoadRemoteConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "web02.lab", config.Credentials.Hostname)
+	assert.Equal(t, 22, config.Credentials.Port)
+	assert.Equal(t, "deploy", config.Credentials.Username)
+	password, err := config.Credentials.PasswordSource.Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "env-s3cret", string(password))
+
+	// Environment only.
+	config, err = run.LoadRemoteConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "web02.lab", config.Credentials.Hostname)
+	assert.Equal(t, "", config.Credentials.Username)
+}
+
+func TestLoadLocalConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := writeConfig(t, dir, "local.yml", `
+dir: /tmp
+env: [GREETING=hello]
+shell: /bin/bash
+`)
+	config, err := run.LoadLocalConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp", config.Dir)
+	assert.Equal(t, []string{"GREETING=hello"}, config.Env)
+	assert.Equal(t, "/bin/bash", config.ShellExecutable)
+
+	defer setenv(t, map[string]string{"GORUN_ENV": "GREETING=hi,NAME=world"})()
+	config, err = run.LoadLocalConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GREETING=hi", "NAME=world"}, config.Env)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name  string
+		data  string
+		field string
+	}{
+		{"port.yaml", "port: 70000", "port"},
+		{"port.json", `{"port": "ssh"}`, "port"},
+		{"port.toml", "port = 22.5", "port"},
+		{"user.yaml", "user: [deploy]", "user"},
+		{"password.yaml", "password: s3cret", "password"},
+		{"password.toml", `password = "vault:deploy"`, "password"},
+		{"unknown.json", `{"hostname": "web01.lab"}`, "hostname"},
+		{"local.yaml", "dir: /tmp", "dir"},
+		{"bytes.yaml", "log_output_bytes: -1", "log_output_bytes"},
+		{"syntax.json", `{"port": `, ""},
+		{"type.ini", "port=22", ""},
+	} {
+		filename := writeConfig(t, dir, tc.name, tc.data)
+		_, err := run.LoadRemoteConfig(filename)
+		t.Logf("err = %v", err)
+		var cerr *run.ConfigError
+		if assert.True(t, errors.As(err, &cerr), tc.name) {
+			assert.Equal(t, filename, cerr.Source, tc.name)
+			assert.Equal(t, tc.field, cerr.Field, tc.name)
+		}
+		assert.NotContains(t, err.Error(), "s3cret")
+	}
+
+	_, err = run.LoadRemoteConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+
+	filename := writeConfig(t, dir, "remote.yaml", "host: web01.lab")
+	_, err = run.LoadLocalConfig(filename)
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	defer setenv(t, map[string]string{"GORUN_PORT": "ssh"})()
+	_, err = run.LoadRemoteConfig(filename)
+	t.Logf("err = %v", err)
+	var cerr *run.ConfigError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "environment", cerr.Source)
+	assert.Equal(t, "GORUN_PORT", cerr.Field)
+}
+
+func TestOpenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := writeConfig(t, dir, "local.toml", `
+url = "local:///tmp?env=NAME=world"
+env = ["GREETING=hello"]
+`)
+	runner, err := run.OpenFile(filename)
+	require.NoError(t, err)
+	stdout, _, _, err := runner.Shell(`echo "$GREETING $NAME from $(pwd)"`)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world from /tmp\n", stdout)
+
+	filename = writeConfig(t, dir, "remote.json", `{"host": "::1", "user": "deploy", "key": "/keys/deploy"}`)
+	runner, err = run.OpenFile(filename, run.WithRemoteConfig(run.RemoteConfig{
+		Credentials: run.Credentials{Port: 2222},
+	}))
+	require.NoError(t, err)
+	remote, ok := runner.(*run.Remote)
+	require.True(t, ok)
+	assert.Equal(t, "::1", remote.Credentials.Hostname)
+	assert.Equal(t, 2222, remote.Credentials.Port)
+	assert.Equal(t, "deploy", remote.Credentials.Username)
+
+	filename = writeConfig(t, dir, "mixed.yaml", "url: ssh://web01.lab\ndir: /tmp")
+	_, err = run.OpenFile(filename)
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
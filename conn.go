@@ -0,0 +1,206 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Reasons a connection to a remote host failed. They are reported by a
+// ConnectionError and can be tested with errors.Is().
+var (
+	// ErrConnectTimeout means the TCP connection or the SSH
+	// handshake did not complete in time.
+	ErrConnectTimeout = errors.New("connection timed out")
+
+	// ErrConnectFailed means the TCP connection or the SSH
+	// handshake failed, e.g., the connection was refused.
+	ErrConnectFailed = errors.New("connection failed")
+
+	// ErrAuthFailed means none of the authentication methods was
+	// accepted.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrConnectionLost means an established connection broke,
+	// e.g., the host stopped answering keepalives.
+	ErrConnectionLost = errors.New("connection lost")
+)
+
+// ConnectionError reports a failure of the connection to a remote
+// host.
+type ConnectionError struct {
+	// User and Host identify the connection.
+	User string
+	Host string
+
+	// Op is the operation that failed: "dial", "handshake", or
+	// "session".
+	Op string
+
+	// Reason is ErrConnectTimeout, ErrConnectFailed,
+	// ErrAuthFailed, or ErrConnectionLost.
+	Reason error
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.Reason == ErrConnectionLost {
+		return fmt.Sprintf("run: connection to %s@%s lost: %v", e.User, e.Host, e.Err)
+	}
+
+	return fmt.Sprintf("run: connection to %s@%s failed: %v", e.User, e.Host, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is the Reason of the error.
+func (e *ConnectionError) Is(target error) bool {
+	return e.Reason != nil && target == e.Reason
+}
+
+// connError returns a ConnectionError for the host.
+func (r *Remote) connError(op string, reason error, err error) *ConnectionError {
+	return &ConnectionError{
+		User:   r.Credentials.Username,
+		Host:   r.Credentials.Hostname,
+		Op:     op,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// isTimeout returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	var nerr net.Error
+
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+// sshConn is an SSH connection that knows whether it was lost.
+type sshConn struct {
+	*ssh.Client
+
+	// done is closed when the connection ends.
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// newSSHConn watches client and sends it keepalives if
+// KeepaliveInterval is set.
+func (r *Remote) newSSHConn(client *ssh.Client) *sshConn {
+	c := &sshConn{Client: client, done: make(chan struct{})}
+	go func() {
+		err := client.Wait()
+		c.mu.Lock()
+		if !c.closed && c.err == nil {
+			c.err = err
+			if c.err == nil {
+				c.err = errors.New("closed by the remote host")
+			}
+		}
+		lost := c.err
+		c.mu.Unlock()
+		close(c.done)
+		if lost != nil {
+			logAttrs(r.Logger, slog.LevelError, "ssh connection lost",
+				append(r.logAttrs(), slog.String("err", lost.Error()))...)
+		}
+	}()
+	if r.KeepaliveInterval > 0 {
+		go r.keepalive(c)
+	}
+
+	return c
+}
+
+// Close closes the connection.
+func (c *sshConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.Client.Close()
+}
+
+// lose closes the connection because it is lost.
+func (c *sshConn) lose(err error) {
+	c.mu.Lock()
+	if !c.closed && c.err == nil {
+		c.err = err
+	}
+	c.mu.Unlock()
+	c.Client.Close() // nolint
+}
+
+// lostErr returns why the connection was lost, or nil if it was not
+// lost. It waits up to wait for the connection to end since a failed
+// session may be noticed before the connection.
+func (c *sshConn) lostErr(wait time.Duration) error {
+	select {
+	case <-c.done:
+	default:
+		select {
+		case <-c.done:
+		case <-time.After(wait):
+			return nil
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// keepalive sends a keepalive@openssh.com request every
+// KeepaliveInterval and closes the connection if KeepaliveMaxMissed
+// intervals pass without a reply. Any reply, including a failure,
+// shows the host is alive.
+func (r *Remote) keepalive(c *sshConn) {
+	ticker := time.NewTicker(r.KeepaliveInterval)
+	defer ticker.Stop()
+	replies := make(chan struct{}, 1)
+	pending := false
+	missed := 0
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-replies:
+			pending = false
+			missed = 0
+		case <-ticker.C:
+			if !pending {
+				pending = true
+				go func() {
+					if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+						replies <- struct{}{}
+					}
+				}()
+				continue
+			}
+			missed++
+			logAttrs(r.Logger, slog.LevelWarn, "ssh keepalive missed",
+				append(r.logAttrs(), slog.Int("missed", missed))...)
+			if missed >= r.KeepaliveMaxMissed {
+				c.lose(fmt.Errorf("no reply to keepalives for %s", time.Duration(missed)*r.KeepaliveInterval))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemote_ConnectionErrors(t *testing.T) {
+	// Nothing listens on a closed listener's port.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close() // nolint
+
+	// A listener that never speaks SSH.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { silent.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint
+		}
+	}()
+
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+
+	tests := []struct {
+		name     string
+		port     int
+		password string
+		op       string
+		reason   error
+	}{
+		{"refused", refusedPort, "s3cret", "dial", run.ErrConnectFailed},
+		{"handshake timeout", silent.Addr().(*net.TCPAddr).Port, "s3cret", "handshake", run.ErrConnectTimeout},
+		{"wrong password", server.Port, "wrong", "handshake", run.ErrAuthFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds := server.Credentials("deploy")
+			creds.Port = test.port
+			creds.Password = test.password
+			r, err := run.NewRemote(run.RemoteConfig{
+				Credentials:      creds,
+				HandshakeTimeout: 200 * time.Millisecond,
+			})
+			require.NoError(t, err)
+			start := time.Now()
+			_, _, _, err = r.Run("true")
+			t.Logf("err = %v", err)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, test.reason))
+			var cerr *run.ConnectionError
+			require.True(t, errors.As(err, &cerr))
+			assert.Equal(t, test.op, cerr.Op)
+			assert.Equal(t, "deploy", cerr.User)
+			assert.Equal(t, "127.0.0.1", cerr.Host)
+			assert.Contains(t, err.Error(), "run: connection to deploy@127.0.0.1 failed")
+			assert.True(t, time.Since(start) < 5*time.Second)
+		})
+	}
+}
+
+func TestRemote_Keepalive(t *testing.T) {
+	// Replies to keepalives, even failures, keep the connection.
+	r, _ := newPasswordRemote(t)
+	r.KeepaliveInterval = 20 * time.Millisecond
+	r.KeepaliveMaxMissed = 2
+	require.NoError(t, r.Connect())
+	time.Sleep(200 * time.Millisecond)
+	stdout, _, code, err := r.Run("echo", "alive")
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "alive\n", stdout)
+
+	// A host that stops answering is detected while a command runs.
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	server.IgnoreKeepalives = true
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+	r, err = run.NewRemote(run.RemoteConfig{
+		Credentials:        creds,
+		KeepaliveInterval:  50 * time.Millisecond,
+		KeepaliveMaxMissed: 2,
+	})
+	require.NoError(t, err)
+	defer r.Close() // nolint
+	require.NoError(t, r.Connect())
+	start := time.Now()
+	_, _, _, err = r.Run("sleep", "10")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectionLost))
+	assert.False(t, errors.Is(err, run.ErrAuthFailed))
+	var cerr *run.ConnectionError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "session", cerr.Op)
+	assert.Contains(t, err.Error(), "run: connection to deploy@127.0.0.1 lost")
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// The next command reconnects.
+	_, _, _, err = r.Run("true")
+	assert.NoError(t, err)
+}
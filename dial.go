@@ -0,0 +1,84 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// DialContext connects to addr on the named network from the remote
+// host through the shared connection, opening it if needed. The "tcp",
+// "tcp4", and "tcp6" networks use direct-tcpip channels and addr is
+// resolved by the remote host. The "unix" network uses
+// direct-streamlocal channels to reach unix sockets such as
+// "/var/run/docker.sock", which OpenSSH servers only allow if
+// AllowStreamLocalForwarding is set.
+//
+// DialContext has the signature used by http.Transport, database
+// drivers, and gRPC, so they can reach services behind the remote
+// host:
+//
+//     client := &http.Client{
+//         Transport: &http.Transport{DialContext: remote.DialContext},
+//     }
+//
+// The returned connections do not support deadlines. If ctx is done
+// before the shared connection is opened or the connection is
+// established, the connection is abandoned and ctx.Err() is returned.
+func (r *Remote) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, fmt.Errorf("run: cannot dial %s %s through %s: unsupported network", network, addr, r.Credentials.Hostname)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, err := r.sharedClient(ctx, true)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial(network, addr)
+		done <- result{conn, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, r.redactError(fmt.Errorf("run: cannot dial %s %s through %s: %w",
+				network, addr, r.Credentials.Hostname, res.err))
+		}
+		logAttrs(r.Logger, slog.LevelDebug, "ssh dial",
+			append(r.logAttrs(),
+				slog.String("network", network),
+				slog.String("addr", addr))...)
+		return res.conn, nil
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close() // nolint
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Dial connects to addr on the named network from the remote host. See
+// DialContext() for details.
+func (r *Remote) Dial(network string, addr string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, addr)
+}
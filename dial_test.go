@@ -0,0 +1,113 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemote_DialContext(t *testing.T) {
+	defer noAgent()()
+	r, server := newPasswordRemote(t)
+
+	// HTTP through the remote host.
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "hello %s", req.URL.Path)
+	}))
+	defer web.Close()
+	client := &http.Client{Transport: &http.Transport{DialContext: r.DialContext}}
+	resp, err := client.Get(web.URL + "/world")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello /world", string(body))
+
+	// Unix sockets.
+	dir, err := ioutil.TempDir("", "dial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "echo.sock")
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			io.Copy(conn, conn) // nolint
+			conn.Close()        // nolint
+		}
+	}()
+	conn, err := r.Dial("unix", sock)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	conn.Close()
+	assert.Equal(t, 1, server.Connections())
+
+	// Errors.
+	_, err = r.Dial("unix", filepath.Join(dir, "missing.sock"))
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	_, err = r.Dial("udp", "127.0.0.1:53")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.DialContext(ctx, "tcp", web.Listener.Addr().String())
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRemote_DialContextConnecting(t *testing.T) {
+	// A host that accepts connections but never answers.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials: run.Credentials{
+			Hostname: addr.IP.String(),
+			Port:     addr.Port,
+			Password: "s3cret",
+		},
+	})
+	require.NoError(t, err)
+
+	// ctx also bounds opening the shared connection.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = r.DialContext(ctx, "tcp", "127.0.0.1:80")
+	t.Logf("err = %v", err)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
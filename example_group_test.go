@@ -0,0 +1,38 @@
+package run_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apatters/go-run"
+)
+
+func ExampleGroup() {
+	// Run commands on at most 2 hosts at a time, giving each
+	// host 10 seconds to complete.
+	group := run.NewGroup(run.GroupConfig{
+		Concurrency: 2,
+		Timeout:     10 * time.Second,
+	})
+
+	// Local runners stand in for Remote runners here.
+	for _, host := range []string{"app01", "app02", "app03"} {
+		group.Add(host, run.NewLocal(run.LocalConfig{
+			Env: []string{"HOSTNAME=" + host},
+		}))
+	}
+
+	fmt.Println("Run hostname command on all hosts.")
+	results := group.ShellAll(`echo "$HOSTNAME"; [ "$HOSTNAME" != app02 ]`)
+	results.Each(func(host string, res *run.Result) {
+		fmt.Printf("%s: stdout = %q, exit code = %d\n", host, res.Stdout, res.ExitCode)
+	})
+	fmt.Printf("failed = %v\n", results.Failed())
+
+	// Output:
+	// Run hostname command on all hosts.
+	// app01: stdout = "app01\n", exit code = 0
+	// app02: stdout = "app02\n", exit code = 1
+	// app03: stdout = "app03\n", exit code = 0
+	// failed = [app02]
+}
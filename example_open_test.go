@@ -0,0 +1,31 @@
+package run_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apatters/go-run"
+)
+
+func ExampleOpen() {
+	// The target would typically be given on the command line,
+	// e.g., "ssh://deploy@web01.lab:2222?key=/home/deploy/.ssh/id_ed25519".
+	target := "local:///tmp?env=GREETING=hello"
+	runner, err := run.Open(target)
+	if err != nil {
+		fmt.Printf("Cannot open %s: %s.\n", target, err)
+		os.Exit(1)
+	}
+
+	stdout, _, code, err := runner.Shell(`echo "$GREETING from $(pwd)"`)
+	if err != nil {
+		fmt.Printf("Internal error running command: %s.\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("stdout = %q\n", stdout)
+	fmt.Printf("exit code = %d\n", code)
+
+	// Output:
+	// stdout = "hello from /tmp\n"
+	// exit code = 0
+}
@@ -0,0 +1,48 @@
+package run_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apatters/go-run"
+)
+
+func ExampleWriteReport() {
+	// Local runners stand in for Remote runners here.
+	group := run.NewGroup(run.GroupConfig{})
+	for i := 1; i <= 15; i++ {
+		host := fmt.Sprintf("app%02d", i)
+		group.Add(host, run.NewLocal(run.LocalConfig{
+			Env: []string{"HOSTNAME=" + host},
+		}))
+	}
+
+	fmt.Println("Check the version on all hosts.")
+	results := group.ShellAll(`
+		case "$HOSTNAME" in
+		app13|app14) echo "version 1.1" ;;
+		*)           echo "version 1.2" ;;
+		esac`)
+	err := run.WriteReport(os.Stdout, results, run.ReportConfig{Diff: true})
+	if err != nil {
+		fmt.Printf("Internal error writing report: %s.\n", err)
+		os.Exit(1)
+	}
+
+	// Output:
+	// Check the version on all hosts.
+	// ------------------
+	// app[01-12,15] (13)
+	// ------------------
+	// version 1.2
+	// exit code: 0
+	// --------------
+	// app[13-14] (2)
+	// --------------
+	// --- app[01-12,15] stdout
+	// +++ app[13-14] stdout
+	// @@ -1 +1 @@
+	// -version 1.2
+	// +version 1.1
+	// exit code: 0
+}
@@ -0,0 +1,47 @@
+package run_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apatters/go-run"
+)
+
+func ExampleRetrier() {
+	// Retry commands that exit with EX_TEMPFAIL (75) up to 5
+	// times, backing off exponentially from 10ms.
+	runner := run.NewRetrier(
+		run.NewLocal(run.LocalConfig{}),
+		run.RetryPolicy{
+			MaxAttempts: 5,
+			Backoff: run.Backoff{
+				Initial: 10 * time.Millisecond,
+				Jitter:  0.2,
+			},
+			RetryIf: []run.RetryPredicate{
+				run.RetryOnTransientError(),
+				run.RetryOnExitCode(75),
+			},
+		})
+
+	fmt.Println("Run a command that always fails temporarily.")
+	res := runner.RunResult("/bin/sh", "-c", "exit 75")
+	fmt.Printf("exit code = %d\n", res.ExitCode)
+	fmt.Printf("attempts = %d\n", len(res.Attempts))
+	fmt.Println()
+
+	fmt.Println("Run a command that fails permanently.")
+	res = runner.RunResult("/bin/sh", "-c", "exit 1")
+	fmt.Printf("exit code = %d\n", res.ExitCode)
+	fmt.Printf("attempts = %d\n", len(res.Attempts))
+	fmt.Println()
+
+	// Output:
+	// Run a command that always fails temporarily.
+	// exit code = 75
+	// attempts = 5
+	//
+	// Run a command that fails permanently.
+	// exit code = 1
+	// attempts = 1
+}
@@ -1,7 +1,16 @@
 module github.com/apatters/go-run
 
+go 1.21
+
 require (
+	github.com/BurntSushi/toml v0.3.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.3.0
-	golang.org/x/crypto v0.0.0-20190131182504-b8fe1690c613
-	golang.org/x/sys v0.0.0-20190204203706-41f3e6584952 // indirect
+	golang.org/x/crypto v0.33.0
+	gopkg.in/yaml.v2 v2.2.8
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 )
@@ -0,0 +1,353 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGroupConcurrency is the maximum number of hosts a
+	// Group runs commands on at the same time if
+	// GroupConfig.Concurrency is not set.
+	DefaultGroupConcurrency = 10
+)
+
+var (
+	// ErrTimeout is the error recorded in a Result when the
+	// command did not complete within the per-host timeout.
+	ErrTimeout = errors.New("run: command timed out")
+
+	// ErrSkipped is the error recorded in a Result when the
+	// command was not run on a host because an earlier host
+	// failed and the Group is in fail-fast mode.
+	ErrSkipped = errors.New("run: command skipped")
+)
+
+// GroupEventType identifies the kind of a GroupEvent.
+type GroupEventType int
+
+const (
+	// HostStarted is sent when a command is started on a host.
+	HostStarted GroupEventType = iota
+
+	// HostFinished is sent when a command completes on a host,
+	// whether it succeeded or not.
+	HostFinished
+
+	// HostSkipped is sent when a command is not run on a host
+	// because of an earlier failure in fail-fast mode.
+	HostSkipped
+)
+
+// String returns the name of the event type.
+func (t GroupEventType) String() string {
+	switch t {
+	case HostStarted:
+		return "started"
+	case HostFinished:
+		return "finished"
+	case HostSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+// GroupEvent reports the progress of a command run on a Group.
+type GroupEvent struct {
+	// Type is the kind of event.
+	Type GroupEventType
+
+	// Host is the host the event applies to.
+	Host string
+
+	// Result is the outcome of the command on Host. It is nil for
+	// HostStarted events.
+	Result *Result
+
+	// Done is the number of hosts that have finished or been
+	// skipped, including this one.
+	Done int
+
+	// Total is the number of hosts the command is being run on.
+	// It is the number of hosts in the Group unless the command
+	// is being run on a subset, e.g., by a Rollout.
+	Total int
+}
+
+// GroupConfig is used to configure the Group constructor.
+type GroupConfig struct {
+	// Concurrency is the maximum number of hosts commands are
+	// run on at the same time. See Group for details.
+	Concurrency int
+
+	// Timeout is the per-host timeout. See Group for details.
+	Timeout time.Duration
+
+	// FailFast stops starting commands on new hosts once one
+	// fails. See Group for details.
+	FailFast bool
+
+	// Progress receives progress events. See Group for details.
+	Progress func(GroupEvent)
+}
+
+// Group runs the same command on many hosts in parallel. Each host is
+// given a name and a Runner when it is added to the Group. Results
+// are returned in the order the hosts were added.
+type Group struct {
+	// Concurrency is the maximum number of hosts commands are
+	// run on at the same time.
+	Concurrency int
+
+	// Timeout is the maximum time a command may run on a single
+	// host. A host whose command does not complete in time is
+	// given a Result with the ErrTimeout error. The Runner
+	// interface cannot interrupt a command, so it is left to
+	// complete in the background and its outcome is discarded.
+	// Zero means no timeout.
+	Timeout time.Duration
+
+	// FailFast, if true, stops commands from being started on
+	// more hosts as soon as a command fails, i.e., returns an
+	// error or exits with a non-zero exit code. Commands already
+	// running are allowed to complete. Hosts that are not run are
+	// given a Result with the ErrSkipped error. If false, the
+	// command is run on every host regardless of failures.
+	FailFast bool
+
+	// Progress, if not nil, is called as commands are started and
+	// completed on each host. Calls are serialized so Progress
+	// does not need to be safe for concurrent use, but it should
+	// return quickly as it blocks other hosts from reporting.
+	Progress func(GroupEvent)
+
+	hosts   []string
+	runners map[string]Runner
+}
+
+// NewGroup is the constructor for Group. It takes a GroupConfig
+// object to configure it. The following configuration options are set
+// if the default GroupConfig constructor, GroupConfig{}, is used:
+//
+//     Concurrency = DefaultGroupConcurrency
+//     Timeout = 0      // No timeout.
+//     FailFast = false // Continue on error.
+//     Progress = nil   // No progress reporting.
+func NewGroup(config GroupConfig) *Group {
+	g := new(Group)
+	g.Concurrency = config.Concurrency
+	if g.Concurrency < 1 {
+		g.Concurrency = DefaultGroupConcurrency
+	}
+	g.Timeout = config.Timeout
+	g.FailFast = config.FailFast
+	g.Progress = config.Progress
+	g.runners = make(map[string]Runner)
+
+	return g
+}
+
+// Add adds a host to the group. Commands are run on the host using
+// runner. If the host is already in the Group its runner is replaced
+// and its position is unchanged.
+func (g *Group) Add(host string, runner Runner) {
+	if g.runners == nil {
+		g.runners = make(map[string]Runner)
+	}
+	if _, ok := g.runners[host]; !ok {
+		g.hosts = append(g.hosts, host)
+	}
+	g.runners[host] = runner
+}
+
+// Hosts returns the names of the hosts in the Group in the order they
+// were added.
+func (g *Group) Hosts() []string {
+	return append([]string(nil), g.hosts...)
+}
+
+// Runner returns the Runner used for host or nil if host is not in
+// the Group.
+func (g *Group) Runner(host string) Runner {
+	return g.runners[host]
+}
+
+// Len returns the number of hosts in the Group.
+func (g *Group) Len() int {
+	return len(g.hosts)
+}
+
+// RunAll runs a command like glibc's exec() call on every host in the
+// Group. It returns the outcome on each host when all have completed.
+func (g *Group) RunAll(cmd string, args ...string) *Results {
+	return g.do(g.hosts, func(runner Runner) *Result {
+		return runResult(runner, cmd, args...)
+	})
+}
+
+// ShellAll runs a command in a shell on every host in the Group. It
+// returns the outcome on each host when all have completed.
+func (g *Group) ShellAll(cmd string) *Results {
+	return g.do(g.hosts, func(runner Runner) *Result {
+		return shellResult(runner, cmd)
+	})
+}
+
+// runOne runs fn on runner, giving up after the per-host timeout.
+func (g *Group) runOne(runner Runner, fn func(Runner) *Result) *Result {
+	if g.Timeout <= 0 {
+		return fn(runner)
+	}
+	ch := make(chan *Result, 1)
+	go func() {
+		ch <- fn(runner)
+	}()
+	timer := time.NewTimer(g.Timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res
+	case <-timer.C:
+		return &Result{Err: ErrTimeout, Duration: g.Timeout}
+	}
+}
+
+// do runs fn on the runners for hosts honoring the concurrency limit
+// and fail-fast mode.
+func (g *Group) do(hosts []string, fn func(Runner) *Result) *Results {
+	results := newResults(hosts)
+	concurrency := g.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultGroupConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	failed := false
+	report := func(ev GroupEvent) {
+		// Called with mu held.
+		if ev.Type != HostStarted {
+			done++
+		}
+		ev.Done = done
+		ev.Total = len(hosts)
+		if g.Progress != nil {
+			g.Progress(ev)
+		}
+	}
+
+	for _, host := range hosts {
+		sem <- struct{}{}
+		mu.Lock()
+		if g.FailFast && failed {
+			res := &Result{Err: ErrSkipped}
+			results.set(host, res)
+			report(GroupEvent{Type: HostSkipped, Host: host, Result: res})
+			mu.Unlock()
+			<-sem
+			continue
+		}
+		report(GroupEvent{Type: HostStarted, Host: host})
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(host string, runner Runner) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := g.runOne(runner, fn)
+			mu.Lock()
+			defer mu.Unlock()
+			if res.Failed() {
+				failed = true
+			}
+			results.set(host, res)
+			report(GroupEvent{Type: HostFinished, Host: host, Result: res})
+		}(host, g.runners[host])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Results is an ordered map from host name to the Result of running a
+// command on that host.
+type Results struct {
+	hosts   []string
+	results map[string]*Result
+}
+
+func newResults(hosts []string) *Results {
+	return &Results{
+		hosts:   append([]string(nil), hosts...),
+		results: make(map[string]*Result, len(hosts)),
+	}
+}
+
+func (r *Results) set(host string, res *Result) {
+	r.results[host] = res
+}
+
+// add appends host to the results if it is not already present and
+// sets its Result.
+func (r *Results) add(host string, res *Result) {
+	if _, ok := r.results[host]; !ok {
+		r.hosts = append(r.hosts, host)
+	}
+	r.results[host] = res
+}
+
+// Hosts returns the host names in order.
+func (r *Results) Hosts() []string {
+	return append([]string(nil), r.hosts...)
+}
+
+// Get returns the Result for host or nil if there is none.
+func (r *Results) Get(host string) *Result {
+	return r.results[host]
+}
+
+// Len returns the number of hosts.
+func (r *Results) Len() int {
+	return len(r.hosts)
+}
+
+// Each calls fn for each host and its Result in order.
+func (r *Results) Each(fn func(host string, res *Result)) {
+	for _, host := range r.hosts {
+		fn(host, r.results[host])
+	}
+}
+
+// Succeeded returns the hosts, in order, on which the command ran
+// and exited with a zero exit code.
+func (r *Results) Succeeded() []string {
+	var hosts []string
+	r.Each(func(host string, res *Result) {
+		if res != nil && !res.Failed() {
+			hosts = append(hosts, host)
+		}
+	})
+
+	return hosts
+}
+
+// Failed returns the hosts, in order, on which the command returned
+// an error, exited with a non-zero exit code, timed out, or was
+// skipped.
+func (r *Results) Failed() []string {
+	var hosts []string
+	r.Each(func(host string, res *Result) {
+		if res == nil || res.Failed() {
+			hosts = append(hosts, host)
+		}
+	})
+
+	return hosts
+}
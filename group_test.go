@@ -0,0 +1,147 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// funcRunner is a Runner that calls fn for every command.
+type funcRunner struct {
+	fn func(cmd string) (string, string, int, error)
+}
+
+func (f *funcRunner) Run(cmd string, args ...string) (string, string, int, error) {
+	return f.fn(f.FormatRun(cmd, args...))
+}
+
+func (f *funcRunner) FormatRun(cmd string, args ...string) string {
+	return strings.TrimSpace(cmd + " " + strings.Join(args, " "))
+}
+
+func (f *funcRunner) Shell(cmd string) (string, string, int, error) {
+	return f.fn(cmd)
+}
+
+func (f *funcRunner) FormatShell(cmd string) string {
+	return cmd
+}
+
+func TestGroup_ShellAll(t *testing.T) {
+	g := run.NewGroup(run.GroupConfig{})
+	hosts := []string{"app10", "app2", "app1"}
+	for _, host := range hosts {
+		g.Add(host, run.NewLocal(run.LocalConfig{
+			Env: []string{"HOST=" + host},
+		}))
+	}
+	assert.Equal(t, hosts, g.Hosts())
+	assert.Equal(t, 3, g.Len())
+
+	results := g.ShellAll(`echo "$HOST"; [ "$HOST" != app2 ]`)
+	require.Equal(t, hosts, results.Hosts())
+	for _, host := range hosts {
+		res := results.Get(host)
+		require.NotNil(t, res)
+		t.Logf("%s: stdout = %q, code = %d", host, res.Stdout, res.ExitCode)
+		assert.Equal(t, host+"\n", res.Stdout)
+		assert.NoError(t, res.Err)
+	}
+	assert.Equal(t, []string{"app10", "app1"}, results.Succeeded())
+	assert.Equal(t, []string{"app2"}, results.Failed())
+	assert.Nil(t, results.Get("xyzzy"))
+}
+
+func TestGroup_RunAll(t *testing.T) {
+	g := run.NewGroup(run.GroupConfig{})
+	g.Add("local", run.NewLocal(run.LocalConfig{}))
+	g.Add("retried", run.NewRetrier(run.NewLocal(run.LocalConfig{}), run.RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     fastBackoff,
+		RetryIf:     []run.RetryPredicate{run.RetryOnExitCode(1)},
+	}))
+
+	results := g.RunAll("/bin/false")
+	assert.Len(t, results.Get("local").Attempts, 1)
+	assert.Len(t, results.Get("retried").Attempts, 2)
+	assert.Equal(t, []string{"local", "retried"}, results.Failed())
+}
+
+func TestGroup_Concurrency(t *testing.T) {
+	var running, maxRunning int32
+	g := run.NewGroup(run.GroupConfig{Concurrency: 3})
+	for i := 0; i < 10; i++ {
+		g.Add(fmt.Sprintf("host%d", i), &funcRunner{fn: func(cmd string) (string, string, int, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return "", "", 0, nil
+		}})
+	}
+	results := g.ShellAll("true")
+
+	assert.Len(t, results.Succeeded(), 10)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&maxRunning))
+}
+
+func TestGroup_Timeout(t *testing.T) {
+	g := run.NewGroup(run.GroupConfig{Timeout: 50 * time.Millisecond})
+	g.Add("fast", run.NewLocal(run.LocalConfig{}))
+	g.Add("slow", &funcRunner{fn: func(cmd string) (string, string, int, error) {
+		time.Sleep(time.Second)
+		return "", "", 0, nil
+	}})
+	start := time.Now()
+	results := g.ShellAll("true")
+
+	assert.True(t, time.Since(start) < time.Second)
+	assert.NoError(t, results.Get("fast").Err)
+	assert.Equal(t, run.ErrTimeout, results.Get("slow").Err)
+	assert.Equal(t, []string{"slow"}, results.Failed())
+}
+
+func TestGroup_FailFast(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	g := run.NewGroup(run.GroupConfig{
+		Concurrency: 1,
+		FailFast:    true,
+		Progress: func(ev run.GroupEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("%s %s %d/%d", ev.Host, ev.Type, ev.Done, ev.Total))
+		},
+	})
+	for _, host := range []string{"a", "b", "c"} {
+		g.Add(host, run.NewLocal(run.LocalConfig{Env: []string{"HOST=" + host}}))
+	}
+	results := g.ShellAll(`[ "$HOST" != b ]`)
+
+	assert.NoError(t, results.Get("a").Err)
+	assert.Equal(t, 1, results.Get("b").ExitCode)
+	assert.Equal(t, run.ErrSkipped, results.Get("c").Err)
+	assert.Equal(t, []string{
+		"a started 0/3",
+		"a finished 1/3",
+		"b started 1/3",
+		"b finished 2/3",
+		"c skipped 3/3",
+	}, events)
+}
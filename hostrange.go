@@ -0,0 +1,296 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hostName is a host name split around its last run of digits.
+type hostName struct {
+	prefix string
+	digits string
+	suffix string
+	value  int
+}
+
+// splitHostName splits name around its last run of digits. The digits
+// are empty if name has none.
+func splitHostName(name string) hostName {
+	end := strings.LastIndexAny(name, "0123456789")
+	if end < 0 {
+		return hostName{prefix: name, value: -1}
+	}
+	start := end
+	for start > 0 && name[start-1] >= '0' && name[start-1] <= '9' {
+		start--
+	}
+	h := hostName{
+		prefix: name[:start],
+		digits: name[start : end+1],
+		suffix: name[end+1:],
+	}
+	v, err := strconv.Atoi(h.digits)
+	if err != nil {
+		// Too many digits to be a range index.
+		return hostName{prefix: name, value: -1}
+	}
+	h.value = v
+
+	return h
+}
+
+// padWidth returns the zero-padded width of the digits or 0 if they
+// are not zero padded.
+func (h hostName) padWidth() int {
+	if len(h.digits) > 1 && h.digits[0] == '0' {
+		return len(h.digits)
+	}
+
+	return 0
+}
+
+// hostPattern is a set of hosts that differ only in one number.
+type hostPattern struct {
+	prefix string
+	suffix string
+	width  int
+	values []int
+}
+
+func (p *hostPattern) String() string {
+	if len(p.values) == 0 {
+		return p.prefix
+	}
+	if len(p.values) == 1 {
+		return fmt.Sprintf("%s%0*d%s", p.prefix, p.width, p.values[0], p.suffix)
+	}
+	var ranges []string
+	for i := 0; i < len(p.values); {
+		j := i
+		for j+1 < len(p.values) && p.values[j+1] == p.values[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprintf("%0*d", p.width, p.values[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%0*d-%0*d", p.width, p.values[i], p.width, p.values[j]))
+		}
+		i = j + 1
+	}
+
+	return fmt.Sprintf("%s[%s]%s", p.prefix, strings.Join(ranges, ","), p.suffix)
+}
+
+// FoldHosts returns a compact representation of a list of host names
+// using ranges, e.g., "app01", "app02", ... "app12", "app15" is folded
+// to "app[01-12,15]". Hosts that cannot be folded are listed
+// individually. Patterns are separated by commas and sorted.
+// Duplicate host names are ignored.
+func FoldHosts(hosts []string) string {
+	type key struct {
+		prefix string
+		suffix string
+		width  int
+	}
+	patterns := make(map[key]*hostPattern)
+	seen := make(map[string]bool)
+	var sorted []*hostPattern
+	var unpadded []hostName
+	for _, host := range hosts {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		h := splitHostName(host)
+		switch {
+		case h.value < 0:
+			sorted = append(sorted, &hostPattern{prefix: host})
+		case h.padWidth() == 0:
+			unpadded = append(unpadded, h)
+		default:
+			k := key{h.prefix, h.suffix, h.padWidth()}
+			if patterns[k] == nil {
+				patterns[k] = &hostPattern{prefix: h.prefix, suffix: h.suffix, width: k.width}
+			}
+			patterns[k].values = append(patterns[k].values, h.value)
+		}
+	}
+	// Numbers without leading zeros, e.g., "10", belong with
+	// zero-padded numbers of the same width, e.g., "09".
+	for _, h := range unpadded {
+		k := key{h.prefix, h.suffix, len(h.digits)}
+		if patterns[k] == nil {
+			k.width = 0
+		}
+		if patterns[k] == nil {
+			patterns[k] = &hostPattern{prefix: h.prefix, suffix: h.suffix}
+		}
+		patterns[k].values = append(patterns[k].values, h.value)
+	}
+
+	for _, p := range patterns {
+		sort.Ints(p.values)
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.prefix != b.prefix {
+			return a.prefix < b.prefix
+		}
+		if a.suffix != b.suffix {
+			return a.suffix < b.suffix
+		}
+		if len(a.values) == 0 || len(b.values) == 0 {
+			return len(a.values) < len(b.values)
+		}
+		if a.values[0] != b.values[0] {
+			return a.values[0] < b.values[0]
+		}
+
+		return a.width < b.width
+	})
+
+	var folded []string
+	for _, p := range sorted {
+		folded = append(folded, p.String())
+	}
+
+	return strings.Join(folded, ",")
+}
+
+// maxExpandedHosts limits the number of hosts a pattern may expand to
+// so a typo such as "web[1-1000000000]" fails instead of exhausting
+// memory.
+const maxExpandedHosts = 1000000
+
+// ExpandHosts returns the host names described by pattern. A pattern
+// is a comma-separated list of host names that may contain ranges in
+// square brackets, e.g., "web[01-20].lab" expands to "web01.lab",
+// "web02.lab", ... "web20.lab". A bracket may contain several ranges
+// and single numbers separated by commas, e.g., "app[01-12,15]", and
+// a name may contain several brackets, e.g., "rack[1-2]-node[1-4]".
+// Numbers are zero padded to the width of the first number of their
+// range. ExpandHosts(FoldHosts(hosts)) returns hosts sorted as by
+// FoldHosts() and without duplicates.
+func ExpandHosts(pattern string) ([]string, error) {
+	parts, err := splitHostPatterns(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, part := range parts {
+		expanded, err := expandHostPattern(part, maxExpandedHosts-len(hosts))
+		if err != nil {
+			return nil, fmt.Errorf("run: invalid host pattern %q: %s", pattern, err)
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	return hosts, nil
+}
+
+// splitHostPatterns splits pattern on commas that are not inside
+// square brackets.
+func splitHostPatterns(pattern string) ([]string, error) {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, c := range pattern {
+		switch c {
+		case '[':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("run: invalid host pattern %q: nested '['", pattern)
+			}
+		case ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("run: invalid host pattern %q: unexpected ']'", pattern)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("run: invalid host pattern %q: missing ']'", pattern)
+	}
+	parts = append(parts, pattern[start:])
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if parts[i] == "" {
+			return nil, fmt.Errorf("run: invalid host pattern %q: empty host name", pattern)
+		}
+	}
+
+	return parts, nil
+}
+
+// expandHostPattern expands a single host pattern containing zero or
+// more bracketed ranges into at most limit host names.
+func expandHostPattern(pattern string, limit int) ([]string, error) {
+	if limit < 1 {
+		return nil, errors.New("too many hosts")
+	}
+	open := strings.IndexByte(pattern, '[')
+	if open < 0 {
+		return []string{pattern}, nil
+	}
+	end := strings.IndexByte(pattern[open:], ']') + open
+	values, err := expandRanges(pattern[open+1:end], limit)
+	if err != nil {
+		return nil, err
+	}
+	// Each value is combined with every expansion of the rest.
+	rest, err := expandHostPattern(pattern[end+1:], limit/len(values))
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, v := range values {
+		for _, r := range rest {
+			hosts = append(hosts, pattern[:open]+v+r)
+		}
+	}
+
+	return hosts, nil
+}
+
+// expandRanges expands the contents of a bracket, e.g., "01-03,07",
+// into at most limit zero-padded numbers.
+func expandRanges(ranges string, limit int) ([]string, error) {
+	var values []string
+	for _, r := range strings.Split(ranges, ",") {
+		r = strings.TrimSpace(r)
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("bad range %q", r)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("bad range %q", r)
+			}
+		}
+		if last-first >= limit-len(values) {
+			return nil, errors.New("too many hosts")
+		}
+		width := len(bounds[0])
+		for v := first; v <= last; v++ {
+			values = append(values, fmt.Sprintf("%0*d", width, v))
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldHosts(t *testing.T) {
+	var apps []string
+	for i := 12; i >= 1; i-- {
+		apps = append(apps, fmt.Sprintf("app%02d", i))
+	}
+	apps = append(apps, "app15")
+
+	for _, tc := range []struct {
+		hosts  []string
+		folded string
+	}{
+		{nil, ""},
+		{[]string{"web01"}, "web01"},
+		{apps, "app[01-12,15]"},
+		{[]string{"node1", "node2", "node3", "node10", "node9"}, "node[1-3,9-10]"},
+		{[]string{"web01.lab", "web02.lab", "web01.prod", "db", "web02.lab"}, "db,web[01-02].lab,web01.prod"},
+		{[]string{"rack1-node03", "rack1-node04", "rack2-node03"}, "rack1-node[03-04],rack2-node03"},
+		{[]string{"app009", "app010", "app1000"}, "app[009-010],app1000"},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, "10.0.0.[1-3]"},
+	} {
+		folded := run.FoldHosts(tc.hosts)
+		t.Logf("hosts = %q", tc.hosts)
+		t.Logf("folded = %q", folded)
+		assert.Equal(t, tc.folded, folded)
+	}
+}
+
+func TestExpandHosts(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		hosts   []string
+	}{
+		{"web01", []string{"web01"}},
+		{"web[01-03].lab", []string{"web01.lab", "web02.lab", "web03.lab"}},
+		{"app[8-10]", []string{"app8", "app9", "app10"}},
+		{"app[01-02,15]", []string{"app01", "app02", "app15"}},
+		{"rack[1-2]-node[1-2]", []string{"rack1-node1", "rack1-node2", "rack2-node1", "rack2-node2"}},
+		{"db, web[1-2]", []string{"db", "web1", "web2"}},
+	} {
+		hosts, err := run.ExpandHosts(tc.pattern)
+		require.NoError(t, err, tc.pattern)
+		assert.Equal(t, tc.hosts, hosts, tc.pattern)
+	}
+
+	for _, pattern := range []string{"", "web[01-", "web01]", "web[[1]]", "web[3-1]", "web[a-b]", "web,,db", "web[1-100000000]",
+		"web[1-600000,1-600000]", "rack[1-2000]-node[1-2000]", "a[1-600000],b[1-600000]"} {
+		_, err := run.ExpandHosts(pattern)
+		t.Logf("err = %v", err)
+		assert.Error(t, err, pattern)
+	}
+
+	// The limit applies to the whole expansion.
+	hosts, err := run.ExpandHosts("rack[1-1000]-node[1-1000]")
+	require.NoError(t, err)
+	assert.Len(t, hosts, 1000000)
+
+	// ExpandHosts(FoldHosts(hosts)) returns hosts sorted and
+	// without duplicates.
+	hosts = []string{"node10", "app02", "db", "app01", "node9", "app02", "app07", "app03"}
+	expanded, err := run.ExpandHosts(run.FoldHosts(hosts))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app01", "app02", "app03", "app07", "db", "node9", "node10"}, expanded)
+}
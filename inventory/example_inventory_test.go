@@ -0,0 +1,54 @@
+package inventory_test
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/apatters/go-run"
+	"github.com/apatters/go-run/inventory"
+)
+
+func ExampleInventory_Select() {
+	inv := inventory.New()
+	groups := []inventory.Group{
+		{
+			Name:  "web",
+			Hosts: []string{"web[01-04].lab"},
+			Config: run.RemoteConfig{
+				Credentials: run.Credentials{
+					Username:           "deploy",
+					PrivateKeyFilename: "/home/deploy/.ssh/id_ed25519",
+				},
+			},
+		},
+		{
+			Name:  "prod",
+			Hosts: []string{"web[01-03].lab", "db01.lab"},
+		},
+	}
+	for _, g := range groups {
+		if err := inv.AddGroup(g); err != nil {
+			fmt.Printf("Invalid group: %s.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	hosts, err := inv.Select("web:&prod:!web03.lab")
+	if err != nil {
+		fmt.Printf("Invalid selection: %s.\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(hosts)
+
+	// Build a Group of Remote runners for the same hosts.
+	group, err := inv.Group("web:&prod:!web03.lab", run.GroupConfig{})
+	if err != nil {
+		fmt.Printf("Cannot create group: %s.\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(run.FoldHosts(group.Hosts()))
+
+	// Output:
+	// [web01.lab web02.lab]
+	// web[01-02].lab
+}
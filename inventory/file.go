@@ -0,0 +1,565 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/apatters/go-run"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Ansible variables that are mapped to run.RemoteConfig fields when
+// an inventory file is loaded. The variables are also kept in the
+// host's or group's Vars. Passwords are not stored in inventories:
+// VarPassword must be a secret reference as accepted by
+// run.ParseSecretRef(), e.g., "env:NAME", "file:/path", or "cmd:pass
+// show deploy", that is read when a connection is made.
+const (
+	VarHost            = "ansible_host"                 // Credentials.Hostname
+	VarPort            = "ansible_port"                 // Credentials.Port
+	VarUser            = "ansible_user"                 // Credentials.Username
+	VarPassword        = "ansible_password"             // Credentials.PasswordSource
+	VarPrivateKeyFile  = "ansible_ssh_private_key_file" // Credentials.PrivateKeyFilename
+	VarShellExecutable = "ansible_shell_executable"     // ShellExecutable
+)
+
+// varAliases maps older Ansible variable names to the current ones.
+var varAliases = map[string]string{
+	"ansible_ssh_host": VarHost,
+	"ansible_ssh_port": VarPort,
+	"ansible_ssh_user": VarUser,
+	"ansible_ssh_pass": VarPassword,
+}
+
+// Load reads an inventory file. The format is chosen by the file name
+// extension: ".yml" and ".yaml" files are read by LoadYAML(), ".json"
+// files by LoadJSON(), and ".ini" files by LoadINI(). Other files are
+// run by LoadScript() if they are executable and read by LoadINI()
+// otherwise.
+func Load(filename string) (*Inventory, error) {
+	var load func(io.Reader) (*Inventory, error)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		load = LoadYAML
+	case ".json":
+		load = LoadJSON
+	case ".ini":
+		load = LoadINI
+	default:
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, fmt.Errorf("inventory: %w", err)
+		}
+		if info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			path, err := filepath.Abs(filename)
+			if err != nil {
+				return nil, fmt.Errorf("inventory: %w", err)
+			}
+			return LoadScript(nil, path)
+		}
+		load = LoadINI
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: %w", err)
+	}
+	defer f.Close()
+	inv, err := load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, filename)
+	}
+
+	return inv, nil
+}
+
+// LoadYAML reads an inventory in Ansible's YAML format, e.g.:
+//
+//     all:
+//       vars:
+//         ansible_user: deploy
+//       children:
+//         web:
+//           hosts:
+//             web[01:20].lab:
+//             web21.lab:
+//               ansible_port: 2222
+//         prod:
+//           children:
+//             web:
+//
+// Host patterns may use Ansible's "[01:20]" or run.ExpandHosts()'s
+// "[01-20]" ranges. Connection variables such as ansible_host,
+// ansible_port, ansible_user, and ansible_ssh_private_key_file set the
+// corresponding run.RemoteConfig fields of the host or group.
+func LoadYAML(r io.Reader) (*Inventory, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: %w", err)
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("inventory: invalid YAML: %w", err)
+	}
+
+	return loadTree(fromYAML(doc))
+}
+
+// LoadJSON reads an inventory in JSON. Both the structure used by
+// LoadYAML() and the structure printed by Ansible dynamic inventory
+// scripts are accepted, e.g.:
+//
+//     {
+//         "web": {
+//             "hosts": ["web01.lab", "web02.lab"],
+//             "vars": {"ansible_user": "deploy"}
+//         },
+//         "prod": {"children": ["web"]},
+//         "_meta": {
+//             "hostvars": {"web02.lab": {"ansible_port": 2222}}
+//         }
+//     }
+func LoadJSON(r io.Reader) (*Inventory, error) {
+	doc, err := parseJSON(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadTree(doc)
+}
+
+// parseJSON decodes a single JSON value from r.
+func parseJSON(r io.Reader) (interface{}, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	doc, err := decodeJSON(dec)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: invalid JSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("inventory: invalid JSON: data after value")
+	}
+
+	return doc, nil
+}
+
+// mapping is a decoded YAML or JSON object whose keys are kept in
+// order so hosts are added to the Inventory in file order.
+type mapping []mappingItem
+
+type mappingItem struct {
+	key   string
+	value interface{}
+}
+
+// fromYAML converts yaml.MapSlice values in v to mappings.
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		m := make(mapping, 0, len(v))
+		for _, item := range v {
+			m = append(m, mappingItem{key: fmt.Sprint(item.Key), value: fromYAML(item.Value)})
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = fromYAML(v[i])
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// decodeJSON decodes the next JSON value from dec keeping the order of
+// object keys.
+func decodeJSON(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		var m mapping
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, mappingItem{key: key.(string), value: value})
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	default:
+		return tok, nil
+	}
+}
+
+// loadTree builds an Inventory from a decoded YAML or JSON document.
+func loadTree(doc interface{}) (*Inventory, error) {
+	inv := New()
+	if doc == nil {
+		return inv, nil
+	}
+	top, ok := doc.(mapping)
+	if !ok {
+		return nil, fmt.Errorf("inventory: expected a mapping of groups, found %s", describe(doc))
+	}
+	var hostVars interface{}
+	for _, item := range top {
+		if item.key == "_meta" {
+			meta, ok := item.value.(mapping)
+			if !ok {
+				return nil, fmt.Errorf("inventory: _meta: expected a mapping, found %s", describe(item.value))
+			}
+			for _, m := range meta {
+				if m.key == "hostvars" {
+					hostVars = m.value
+				}
+			}
+			continue
+		}
+		if err := loadGroup(inv, item.key, item.value); err != nil {
+			return nil, fmt.Errorf("inventory: %w", err)
+		}
+	}
+	if hostVars != nil {
+		if err := loadHosts(inv, All, hostVars); err != nil {
+			return nil, fmt.Errorf("inventory: _meta: %w", err)
+		}
+	}
+
+	return inv, nil
+}
+
+// loadGroup adds the group described by node to inv.
+func loadGroup(inv *Inventory, name string, node interface{}) error {
+	if err := inv.AddGroup(Group{Name: name}); err != nil {
+		return err
+	}
+	switch node := node.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		// Dynamic inventory shorthand for a group with only hosts.
+		if err := loadHosts(inv, name, node); err != nil {
+			return fmt.Errorf("group %q: %w", name, err)
+		}
+		return nil
+	case mapping:
+		for _, item := range node {
+			var err error
+			switch item.key {
+			case "hosts":
+				err = loadHosts(inv, name, item.value)
+			case "vars":
+				var vars map[string]string
+				vars, err = toVars(item.value)
+				if err == nil {
+					err = addGroupVars(inv, name, vars)
+				}
+			case "children":
+				err = loadChildren(inv, name, item.value)
+			default:
+				err = fmt.Errorf("unknown key %q", item.key)
+			}
+			if err != nil {
+				return fmt.Errorf("group %q: %w", name, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("group %q: expected a mapping, found %s", name, describe(node))
+	}
+}
+
+// loadHosts adds the hosts in node, either a list of host patterns or
+// a mapping from host pattern to host variables, to the named group.
+func loadHosts(inv *Inventory, group string, node interface{}) error {
+	switch node := node.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		for _, v := range node {
+			pattern, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("expected a host name, found %s", describe(v))
+			}
+			if err := addHosts(inv, group, pattern, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	case mapping:
+		for _, item := range node {
+			vars, err := toVars(item.value)
+			if err != nil {
+				return fmt.Errorf("host %q: %w", item.key, err)
+			}
+			if err := addHosts(inv, group, item.key, vars); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("expected hosts, found %s", describe(node))
+	}
+}
+
+// loadChildren adds the child groups in node, either a list of group
+// names or a mapping from group name to group, to the named group.
+func loadChildren(inv *Inventory, group string, node interface{}) error {
+	switch node := node.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		for _, v := range node {
+			child, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("expected a group name, found %s", describe(v))
+			}
+			if err := addChild(inv, group, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case mapping:
+		for _, item := range node {
+			if err := loadGroup(inv, item.key, item.value); err != nil {
+				return err
+			}
+			if err := addChild(inv, group, item.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("expected child groups, found %s", describe(node))
+	}
+}
+
+// addHosts adds the hosts named by an Ansible host pattern to group
+// and sets their variables.
+func addHosts(inv *Inventory, group string, pattern string, vars map[string]string) error {
+	pattern = ansiblePattern(pattern)
+	hosts, err := run.ExpandHosts(pattern)
+	if err != nil {
+		return err
+	}
+	if group != All {
+		if err := inv.AddGroup(Group{Name: group, Hosts: hosts}); err != nil {
+			return err
+		}
+	}
+	config, err := ansibleConfig(vars)
+	if err != nil {
+		return fmt.Errorf("host %q: %w", pattern, err)
+	}
+	for _, host := range hosts {
+		err := inv.AddHost(Host{Name: host, Config: config, Vars: vars})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addGroupVars sets the variables of group.
+func addGroupVars(inv *Inventory, group string, vars map[string]string) error {
+	config, err := ansibleConfig(vars)
+	if err != nil {
+		return err
+	}
+
+	return inv.AddGroup(Group{Name: group, Config: config, Vars: vars})
+}
+
+// addChild makes child a child group of group, creating child if it
+// does not exist.
+func addChild(inv *Inventory, group string, child string) error {
+	if err := inv.AddGroup(Group{Name: child}); err != nil {
+		return err
+	}
+	if group == All {
+		return nil
+	}
+
+	return inv.AddGroup(Group{Name: group, Children: []string{child}})
+}
+
+// ansiblePattern converts Ansible's "[01:20]" ranges to the "[01-20]"
+// ranges used by run.ExpandHosts().
+func ansiblePattern(pattern string) string {
+	b := []byte(pattern)
+	in := false
+	for i, c := range b {
+		switch {
+		case c == '[':
+			in = true
+		case c == ']':
+			in = false
+		case c == ':' && in:
+			b[i] = '-'
+		}
+	}
+
+	return string(b)
+}
+
+// ansibleConfig returns the run.RemoteConfig fields set by the Ansible
+// connection variables in vars.
+func ansibleConfig(vars map[string]string) (run.RemoteConfig, error) {
+	var config run.RemoteConfig
+	for name, value := range vars {
+		if alias, ok := varAliases[name]; ok {
+			if _, ok := vars[alias]; ok {
+				// The current name takes precedence.
+				continue
+			}
+			name = alias
+		}
+		switch name {
+		case VarHost:
+			config.Credentials.Hostname = value
+		case VarPort:
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return config, fmt.Errorf("invalid %s %q", VarPort, value)
+			}
+			config.Credentials.Port = port
+		case VarUser:
+			config.Credentials.Username = value
+		case VarPassword:
+			source, err := run.ParseSecretRef(value)
+			if err != nil {
+				// The value may be the password itself.
+				return config, fmt.Errorf("%s must be a secret reference such as env:NAME, file:/path, or cmd:command, not the password itself", VarPassword)
+			}
+			config.Credentials.PasswordSource = source
+		case VarPrivateKeyFile:
+			config.Credentials.PrivateKeyFilename = expandHome(value)
+		case VarShellExecutable:
+			config.ShellExecutable = value
+		}
+	}
+
+	return config, nil
+}
+
+// expandHome replaces a leading "~/" in filename with the current
+// user's home directory.
+func expandHome(filename string) string {
+	if !strings.HasPrefix(filename, "~/") {
+		return filename
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filename
+	}
+
+	return filepath.Join(home, filename[2:])
+}
+
+// toVars converts a decoded mapping of variables to strings. Values
+// that are lists or mappings are converted to JSON.
+func toVars(node interface{}) (map[string]string, error) {
+	if node == nil {
+		return nil, nil
+	}
+	m, ok := node.(mapping)
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping of variables, found %s", describe(node))
+	}
+	vars := make(map[string]string, len(m))
+	for _, item := range m {
+		switch v := item.value.(type) {
+		case nil:
+			vars[item.key] = ""
+		case string:
+			vars[item.key] = v
+		case mapping, []interface{}:
+			var b bytes.Buffer
+			writeJSON(&b, v)
+			vars[item.key] = b.String()
+		default:
+			vars[item.key] = fmt.Sprint(v)
+		}
+	}
+
+	return vars, nil
+}
+
+// writeJSON writes v, a decoded YAML or JSON value, to b as JSON.
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case mapping:
+		b.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, item.key)
+			b.WriteByte(':')
+			writeJSON(b, item.value)
+		}
+		b.WriteByte('}')
+	case []interface{}:
+		b.WriteByte('[')
+		for i := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeJSON(b, v[i])
+		}
+		b.WriteByte(']')
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(v))
+		}
+		b.Write(data)
+	}
+}
+
+// describe returns the kind of a decoded YAML or JSON value for error
+// messages.
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case mapping:
+		return "a mapping"
+	case []interface{}:
+		return "a list"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%q", fmt.Sprint(v))
+	}
+}
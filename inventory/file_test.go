@@ -0,0 +1,162 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/apatters/go-run/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlInventory = `
+all:
+  vars:
+    ansible_user: admin
+    ansible_ssh_private_key_file: /keys/admin
+    dns: [10.0.0.53, 10.0.1.53]
+  hosts:
+    bastion.lab:
+      ansible_port: 2222
+  children:
+    web:
+      vars:
+        ansible_user: deploy
+      hosts:
+        web[01:03].lab:
+        web04.lab:
+          ansible_host: 10.0.0.4
+          ansible_ssh_pass: env:WEB04_PASSWORD
+    prod:
+      children:
+        web:
+        db:
+          hosts:
+            db01.lab:
+`
+
+const jsonInventory = `{
+    "web": {
+        "hosts": ["web01.lab", "web02.lab", "web03.lab", "web04.lab"],
+        "vars": {"ansible_user": "deploy"}
+    },
+    "db": ["db01.lab"],
+    "prod": {"children": ["web", "db"]},
+    "all": {
+        "hosts": ["bastion.lab"],
+        "vars": {
+            "ansible_user": "admin",
+            "ansible_ssh_private_key_file": "/keys/admin",
+            "dns": ["10.0.0.53", "10.0.1.53"]
+        }
+    },
+    "_meta": {
+        "hostvars": {
+            "bastion.lab": {"ansible_port": 2222},
+            "web04.lab": {"ansible_host": "10.0.0.4", "ansible_ssh_pass": "env:WEB04_PASSWORD"}
+        }
+    }
+}`
+
+// checkInventory checks an inventory loaded from yamlInventory,
+// jsonInventory, or iniInventory.
+func checkInventory(t *testing.T, inv *inventory.Inventory) {
+	assert.ElementsMatch(t, []string{"bastion.lab", "web01.lab", "web02.lab", "web03.lab", "web04.lab", "db01.lab"}, inv.Hosts())
+
+	hosts, err := inv.Select("prod:!web04.lab")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"web01.lab", "web02.lab", "web03.lab", "db01.lab"}, hosts)
+
+	config, err := inv.Config("bastion.lab")
+	require.NoError(t, err)
+	assert.Equal(t, "bastion.lab", config.Credentials.Hostname)
+	assert.Equal(t, 2222, config.Credentials.Port)
+	assert.Equal(t, "admin", config.Credentials.Username)
+	assert.Equal(t, "/keys/admin", config.Credentials.PrivateKeyFilename)
+
+	config, err = inv.Config("web04.lab")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.4", config.Credentials.Hostname)
+	assert.Equal(t, 0, config.Credentials.Port)
+	assert.Equal(t, "deploy", config.Credentials.Username)
+	assert.Empty(t, config.Credentials.Password)
+	assert.Equal(t, run.EnvSecret("WEB04_PASSWORD"), config.Credentials.PasswordSource)
+
+	config, err = inv.Config("db01.lab")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", config.Credentials.Username)
+
+	vars, err := inv.Vars("web01.lab")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", vars["ansible_user"])
+	assert.Equal(t, `["10.0.0.53","10.0.1.53"]`, vars["dns"])
+}
+
+func TestLoadYAML(t *testing.T) {
+	inv, err := inventory.LoadYAML(strings.NewReader(yamlInventory))
+	require.NoError(t, err)
+	checkInventory(t, inv)
+	assert.Equal(t, []string{"bastion.lab", "web01.lab", "web02.lab", "web03.lab", "web04.lab", "db01.lab"}, inv.Hosts())
+
+	for _, doc := range []string{
+		"- web01",
+		"all:\n  hosts: web01",
+		"all:\n  host:\n    web01:",
+		"web:\n  hosts:\n    web01:\n      ansible_port: ssh",
+		"web:\n  hosts:\n    web01:\n      ansible_password: s3cret",
+		"web:\n  hosts:\n    web[01:\n",
+		"all: [",
+	} {
+		_, err := inventory.LoadYAML(strings.NewReader(doc))
+		t.Logf("err = %v", err)
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	inv, err := inventory.LoadJSON(strings.NewReader(jsonInventory))
+	require.NoError(t, err)
+	checkInventory(t, inv)
+	assert.Equal(t, []string{"web01.lab", "web02.lab", "web03.lab", "web04.lab", "db01.lab", "bastion.lab"}, inv.Hosts())
+
+	for _, doc := range []string{
+		`["web01"]`,
+		`{"web": {"hosts": [1]}}`,
+		`{"web": {"hosts": ["web01"]}} {}`,
+		`{"_meta": {"hostvars": {"web01": {"ansible_port": "0"}}}}`,
+		`{"web": `,
+	} {
+		_, err := inventory.LoadJSON(strings.NewReader(doc))
+		t.Logf("err = %v", err)
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, doc := range map[string]string{
+		"hosts.yml":  yamlInventory,
+		"hosts.json": jsonInventory,
+		"hosts":      iniInventory,
+	} {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(filename, []byte(doc), 0644))
+		inv, err := inventory.Load(filename)
+		require.NoError(t, err, name)
+		checkInventory(t, inv)
+	}
+
+	_, err = inventory.Load(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+}
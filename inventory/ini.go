@@ -0,0 +1,182 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Ungrouped is the group LoadINI() adds hosts listed before the first
+// section to.
+const Ungrouped = "ungrouped"
+
+// LoadINI reads an inventory in Ansible's INI format, e.g.:
+//
+//     bastion.lab ansible_port=2222
+//
+//     [web]
+//     web[01:20].lab
+//     web21.lab ansible_host=10.0.0.21 ansible_user=admin
+//
+//     [web:vars]
+//     ansible_user=deploy
+//     ansible_ssh_private_key_file=~/.ssh/deploy
+//
+//     [prod:children]
+//     web
+//
+// Hosts listed before the first section are added to the Ungrouped
+// group. Lines starting with '#' or ';' are comments. Values
+// containing spaces may be quoted. Connection variables are mapped to
+// run.RemoteConfig fields as described for LoadYAML().
+func LoadINI(r io.Reader) (*Inventory, error) {
+	inv := New()
+	group := Ungrouped
+	kind := ""
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		var err error
+		if line[0] == '[' {
+			group, kind, err = iniSection(line)
+			if err == nil {
+				err = inv.AddGroup(Group{Name: group})
+			}
+		} else {
+			switch kind {
+			case "":
+				err = iniHost(inv, group, line)
+			case "vars":
+				err = iniVar(inv, group, line)
+			case "children":
+				err = addChild(inv, group, line)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("inventory: line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("inventory: %w", err)
+	}
+
+	return inv, nil
+}
+
+// iniSection parses a section header such as "[web]", "[web:vars]", or
+// "[web:children]" into the group name and the section kind.
+func iniSection(line string) (string, string, error) {
+	if !strings.HasSuffix(line, "]") {
+		return "", "", fmt.Errorf("invalid section %s", line)
+	}
+	name := strings.TrimSpace(line[1 : len(line)-1])
+	kind := ""
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name, kind = name[:i], name[i+1:]
+		if kind != "vars" && kind != "children" {
+			return "", "", fmt.Errorf("invalid section %s: unknown kind %q", line, kind)
+		}
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid section %s", line)
+	}
+
+	return name, kind, nil
+}
+
+// iniHost parses a host line, i.e., a host pattern followed by
+// variables, and adds the hosts to group.
+func iniHost(inv *Inventory, group string, line string) error {
+	fields, err := iniFields(line)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	var vars map[string]string
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("host %q: expected name=value, found %q", fields[0], field)
+		}
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		vars[kv[0]] = kv[1]
+	}
+
+	return addHosts(inv, group, fields[0], vars)
+}
+
+// iniVar parses a "name=value" line in a vars section and sets the
+// variable for group.
+func iniVar(inv *Inventory, group string, line string) error {
+	kv := strings.SplitN(line, "=", 2)
+	name := strings.TrimSpace(kv[0])
+	if len(kv) != 2 || name == "" {
+		return fmt.Errorf("group %q: expected name=value, found %q", group, line)
+	}
+	fields, err := iniFields(kv[1])
+	if err != nil {
+		return err
+	}
+	value := strings.Join(fields, " ")
+
+	return addGroupVars(inv, group, map[string]string{name: value})
+}
+
+// iniFields splits line into whitespace-separated fields. Single or
+// double quotes group characters including whitespace into a field
+// and are removed. An unquoted '#' starts a comment.
+func iniFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	inField := false
+	quote := rune(0)
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				field.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == '#':
+			if inField {
+				fields = append(fields, field.String())
+			}
+			return fields, nil
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const iniInventory = `
+# Jump host.
+bastion.lab ansible_port=2222
+
+[web]
+web[01:03].lab
+web04.lab ansible_host=10.0.0.4 ansible_ssh_pass=env:WEB04_PASSWORD # Old hardware.
+
+[web:vars]
+ansible_user = deploy
+
+[db]
+db01.lab
+
+[prod:children]
+web
+db
+
+; Defaults for every host.
+[all:vars]
+ansible_user=admin
+ansible_ssh_private_key_file="/keys/admin"
+dns='["10.0.0.53","10.0.1.53"]'
+`
+
+func TestLoadINI(t *testing.T) {
+	inv, err := inventory.LoadINI(strings.NewReader(iniInventory))
+	require.NoError(t, err)
+	checkInventory(t, inv)
+	assert.Equal(t, []string{"bastion.lab", "web01.lab", "web02.lab", "web03.lab", "web04.lab", "db01.lab"}, inv.Hosts())
+
+	hosts, err := inv.Select(inventory.Ungrouped)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bastion.lab"}, hosts)
+
+	inv, err = inventory.LoadINI(strings.NewReader(`[web]
+web01 motd="Hello, world" empty=""`))
+	require.NoError(t, err)
+	vars, err := inv.Vars("web01")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"motd": "Hello, world", "empty": ""}, vars)
+
+	for _, doc := range []string{
+		"[web",
+		"[web:hosts]",
+		"[:vars]",
+		"web01 ansible_port",
+		"web01 motd=\"Hello",
+		"web01 ansible_port=ssh",
+		"web01 ansible_password=s3cret",
+		"[web:vars]\nansible_user",
+		"web[01:",
+	} {
+		_, err := inventory.LoadINI(strings.NewReader(doc))
+		t.Logf("err = %v", err)
+		assert.Error(t, err, doc)
+	}
+}
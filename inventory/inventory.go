@@ -0,0 +1,610 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+// Package inventory describes the hosts commands are run on. An
+// Inventory contains hosts and named groups of hosts. Groups supply
+// default run.RemoteConfig settings, variables, and tags to their
+// hosts, and hosts are selected with Ansible-style expressions such as
+// "web:&prod:!web03". Selected hosts are turned into configured
+// run.Remote runners or a run.Group.
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/apatters/go-run"
+)
+
+// All is the name of the implicit group containing every host in the
+// Inventory. Its Config, Vars, and Tags apply to every host.
+const All = "all"
+
+// Host is a single host in an Inventory.
+type Host struct {
+	// Name is the name of the host. It is used as
+	// Config.Credentials.Hostname unless that is set.
+	Name string
+
+	// Config contains connection settings for the host. Fields
+	// that are set override those of the host's groups.
+	Config run.RemoteConfig
+
+	// Vars are variables for the host. They override variables of
+	// the same name set by the host's groups.
+	Vars map[string]string
+
+	// Tags are labels used to select the host. The host also has
+	// the tags of its groups.
+	Tags []string
+}
+
+// Group is a named set of hosts and child groups. A host in a child
+// group is also in its parent groups.
+type Group struct {
+	// Name is the name of the group.
+	Name string
+
+	// Hosts are the hosts in the group. Each entry may be a host
+	// range pattern such as "web[01-20].lab" as accepted by
+	// run.ExpandHosts(). Hosts that are not in the Inventory are
+	// added to it.
+	Hosts []string
+
+	// Children are the names of groups whose hosts are also in
+	// this group.
+	Children []string
+
+	// Config contains default connection settings for the hosts
+	// in the group. Credentials.Hostname is ignored.
+	Config run.RemoteConfig
+
+	// Vars are default variables for the hosts in the group.
+	Vars map[string]string
+
+	// Tags are labels given to every host in the group.
+	Tags []string
+}
+
+// Inventory is a set of hosts and groups. Hosts are kept in the order
+// they were first added.
+type Inventory struct {
+	hosts      map[string]*Host
+	hostOrder  []string
+	groups     map[string]*Group
+	groupOrder []string
+}
+
+// New returns an empty Inventory containing only the All group.
+func New() *Inventory {
+	inv := &Inventory{
+		hosts:  make(map[string]*Host),
+		groups: make(map[string]*Group),
+	}
+	inv.groups[All] = &Group{Name: All}
+	inv.groupOrder = []string{All}
+
+	return inv
+}
+
+// AddHost adds a host to the Inventory. If a host of the same name is
+// already present its Config, Vars, and Tags are merged with those of
+// host, with the values of host taking precedence.
+func (inv *Inventory) AddHost(host Host) error {
+	if host.Name == "" {
+		return fmt.Errorf("inventory: host has no name")
+	}
+	h := inv.host(host.Name)
+	mergeConfig(&h.Config, host.Config)
+	h.Vars = mergeVars(h.Vars, host.Vars)
+	h.Tags = appendUnique(h.Tags, host.Tags...)
+
+	return nil
+}
+
+// AddGroup adds a group to the Inventory. Host patterns are expanded
+// and any hosts not yet in the Inventory are added to it. If a group
+// of the same name is already present, the hosts, children, and tags
+// are added to it and its Config and Vars are merged with those of
+// group, with the values of group taking precedence.
+func (inv *Inventory) AddGroup(group Group) error {
+	if group.Name == "" {
+		return fmt.Errorf("inventory: group has no name")
+	}
+	var hosts []string
+	for _, pattern := range group.Hosts {
+		expanded, err := run.ExpandHosts(pattern)
+		if err != nil {
+			return fmt.Errorf("inventory: group %q: %w", group.Name, err)
+		}
+		hosts = append(hosts, expanded...)
+	}
+
+	g := inv.groups[group.Name]
+	if g == nil {
+		g = &Group{Name: group.Name}
+		inv.groups[group.Name] = g
+		inv.groupOrder = append(inv.groupOrder, group.Name)
+	}
+	for _, name := range hosts {
+		inv.host(name)
+	}
+	g.Hosts = appendUnique(g.Hosts, hosts...)
+	g.Children = appendUnique(g.Children, group.Children...)
+	mergeConfig(&g.Config, group.Config)
+	g.Vars = mergeVars(g.Vars, group.Vars)
+	g.Tags = appendUnique(g.Tags, group.Tags...)
+
+	return nil
+}
+
+// host returns the named host, adding it if necessary.
+func (inv *Inventory) host(name string) *Host {
+	h := inv.hosts[name]
+	if h == nil {
+		h = &Host{Name: name}
+		inv.hosts[name] = h
+		inv.hostOrder = append(inv.hostOrder, name)
+	}
+
+	return h
+}
+
+// Hosts returns the names of all hosts in the order they were added.
+func (inv *Inventory) Hosts() []string {
+	return append([]string(nil), inv.hostOrder...)
+}
+
+// Groups returns the names of all groups, starting with All, in the
+// order they were added.
+func (inv *Inventory) Groups() []string {
+	return append([]string(nil), inv.groupOrder...)
+}
+
+// GroupHosts returns the hosts in the named group and its descendants
+// in inventory order. It returns an error if there is no such group or
+// if the group is its own descendant.
+func (inv *Inventory) GroupHosts(name string) ([]string, error) {
+	set := make(map[string]bool)
+	if err := inv.collect(name, set, nil); err != nil {
+		return nil, err
+	}
+
+	return inv.ordered(set), nil
+}
+
+// collect adds the hosts in the named group and its descendants to
+// set. path holds the groups being visited to detect cycles.
+func (inv *Inventory) collect(name string, set map[string]bool, path []string) error {
+	g := inv.groups[name]
+	if g == nil {
+		return fmt.Errorf("inventory: unknown group %q", name)
+	}
+	for _, p := range path {
+		if p == name {
+			return fmt.Errorf("inventory: group %q is its own descendant: %s",
+				name, strings.Join(append(path, name), " > "))
+		}
+	}
+	if name == All {
+		for _, host := range inv.hostOrder {
+			set[host] = true
+		}
+		return nil
+	}
+	for _, host := range g.Hosts {
+		set[host] = true
+	}
+	for _, child := range g.Children {
+		if err := inv.collect(child, set, append(path, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hostGroups returns the groups containing host, directly or through
+// a child group, from the least to the most specific: All first,
+// then parents before their children, otherwise in the order the
+// groups were added.
+func (inv *Inventory) hostGroups(host string) ([]*Group, error) {
+	depth := make(map[string]int)
+	var visit func(name string, d int, path []string) (bool, error)
+	visit = func(name string, d int, path []string) (bool, error) {
+		g := inv.groups[name]
+		if g == nil {
+			return false, fmt.Errorf("inventory: unknown group %q", name)
+		}
+		for _, p := range path {
+			if p == name {
+				return false, fmt.Errorf("inventory: group %q is its own descendant: %s",
+					name, strings.Join(append(path, name), " > "))
+			}
+		}
+		found := contains(g.Hosts, host)
+		for _, child := range g.Children {
+			in, err := visit(child, d+1, append(path, name))
+			if err != nil {
+				return false, err
+			}
+			found = found || in
+		}
+		if old, ok := depth[name]; found && (!ok || d > old) {
+			depth[name] = d
+		}
+
+		return found, nil
+	}
+	for _, name := range inv.groupOrder[1:] {
+		if _, err := visit(name, 1, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	groups := []*Group{inv.groups[All]}
+	var names []string
+	for _, name := range inv.groupOrder {
+		if _, ok := depth[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return depth[names[i]] < depth[names[j]]
+	})
+	for _, name := range names {
+		groups = append(groups, inv.groups[name])
+	}
+
+	return groups, nil
+}
+
+// Config returns the effective connection settings for host: the
+// settings of its groups, from the least to the most specific,
+// overridden by those of the host. Credentials.Hostname is the host
+// name unless it is set for the host.
+func (inv *Inventory) Config(host string) (run.RemoteConfig, error) {
+	h := inv.hosts[host]
+	if h == nil {
+		return run.RemoteConfig{}, fmt.Errorf("inventory: unknown host %q", host)
+	}
+	groups, err := inv.hostGroups(host)
+	if err != nil {
+		return run.RemoteConfig{}, err
+	}
+	var config run.RemoteConfig
+	for _, g := range groups {
+		mergeConfig(&config, g.Config)
+	}
+	config.Credentials.Hostname = ""
+	mergeConfig(&config, h.Config)
+	if config.Credentials.Hostname == "" {
+		config.Credentials.Hostname = h.Name
+	}
+
+	return config, nil
+}
+
+// Vars returns the effective variables for host: the variables of its
+// groups, from the least to the most specific, overridden by those of
+// the host.
+func (inv *Inventory) Vars(host string) (map[string]string, error) {
+	h := inv.hosts[host]
+	if h == nil {
+		return nil, fmt.Errorf("inventory: unknown host %q", host)
+	}
+	groups, err := inv.hostGroups(host)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, g := range groups {
+		vars = mergeVars(vars, g.Vars)
+	}
+
+	return mergeVars(vars, h.Vars), nil
+}
+
+// Tags returns the tags of host and of all its groups.
+func (inv *Inventory) Tags(host string) ([]string, error) {
+	h := inv.hosts[host]
+	if h == nil {
+		return nil, fmt.Errorf("inventory: unknown host %q", host)
+	}
+	groups, err := inv.hostGroups(host)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, g := range groups {
+		tags = appendUnique(tags, g.Tags...)
+	}
+
+	return appendUnique(tags, h.Tags...), nil
+}
+
+// Select returns the hosts matched by a selection expression in
+// inventory order. An expression is a list of terms separated by ':'
+// or ','. A term is one of:
+//
+//     all or *       Every host.
+//     group          The hosts in the group and its descendants.
+//     tag            The hosts with the tag.
+//     host           The host.
+//     web[01-05]     The hosts named by a host range pattern.
+//     web*           The hosts whose names match a shell glob.
+//
+// Terms prefixed by '&' are intersected with, and terms prefixed by
+// '!' are removed from, the union of the other terms. For example,
+// "web:db:&prod:!web03" selects the hosts in the web or db groups
+// that are tagged or grouped prod, except web03. A term that matches
+// no group, tag, or host is an error so that a misspelled exclusion
+// does not silently select a host.
+func (inv *Inventory) Select(expr string) ([]string, error) {
+	terms, err := splitTerms(expr)
+	if err != nil {
+		return nil, err
+	}
+	union := make(map[string]bool)
+	var intersect []map[string]bool
+	exclude := make(map[string]bool)
+	hasUnion := false
+	for _, term := range terms {
+		op := byte(0)
+		if term[0] == '&' || term[0] == '!' {
+			op = term[0]
+			term = term[1:]
+		}
+		set, err := inv.match(term)
+		if err != nil {
+			return nil, fmt.Errorf("inventory: invalid selection %q: %w", expr, err)
+		}
+		switch op {
+		case '&':
+			intersect = append(intersect, set)
+		case '!':
+			for host := range set {
+				exclude[host] = true
+			}
+		default:
+			hasUnion = true
+			for host := range set {
+				union[host] = true
+			}
+		}
+	}
+	if !hasUnion {
+		// "&prod" and "!web03" alone apply to every host.
+		for _, host := range inv.hostOrder {
+			union[host] = true
+		}
+	}
+
+	var hosts []string
+	for _, host := range inv.hostOrder {
+		if !union[host] || exclude[host] {
+			continue
+		}
+		in := true
+		for _, set := range intersect {
+			in = in && set[host]
+		}
+		if in {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts, nil
+}
+
+// splitTerms splits a selection expression on ':' and ',' outside of
+// square brackets.
+func splitTerms(expr string) ([]string, error) {
+	var terms []string
+	depth := 0
+	start := 0
+	for i, c := range expr {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':', ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, expr[start:])
+	for i, term := range terms {
+		terms[i] = strings.TrimSpace(term)
+		if terms[i] == "" || terms[i] == "&" || terms[i] == "!" {
+			return nil, fmt.Errorf("inventory: invalid selection %q: empty term", expr)
+		}
+	}
+
+	return terms, nil
+}
+
+// match returns the hosts matched by a single selection term.
+func (inv *Inventory) match(term string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	found := false
+	if term == "*" {
+		term = All
+	}
+	if _, ok := inv.groups[term]; ok {
+		found = true
+		if err := inv.collect(term, set, nil); err != nil {
+			return nil, err
+		}
+	}
+	for _, host := range inv.hostOrder {
+		tags, err := inv.Tags(host)
+		if err != nil {
+			return nil, err
+		}
+		if contains(tags, term) {
+			found = true
+			set[host] = true
+		}
+	}
+	if _, ok := inv.hosts[term]; ok {
+		found = true
+		set[term] = true
+	}
+	switch {
+	case strings.ContainsAny(term, "*?"):
+		for _, host := range inv.hostOrder {
+			ok, err := path.Match(term, host)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", term, err)
+			}
+			if ok {
+				found = true
+				set[host] = true
+			}
+		}
+	case strings.Contains(term, "["):
+		hosts, err := run.ExpandHosts(term)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if _, ok := inv.hosts[host]; ok {
+				found = true
+				set[host] = true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no group, tag, or host matches %q", term)
+	}
+
+	return set, nil
+}
+
+// ordered returns the hosts in set in inventory order.
+func (inv *Inventory) ordered(set map[string]bool) []string {
+	var hosts []string
+	for _, host := range inv.hostOrder {
+		if set[host] {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// Remote returns a run.Remote configured for host using its effective
+// connection settings.
+func (inv *Inventory) Remote(host string) (*run.Remote, error) {
+	config, err := inv.Config(host)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := run.NewRemote(config)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: host %q: %w", host, err)
+	}
+
+	return remote, nil
+}
+
+// Remotes returns the hosts matched by the selection expression, in
+// inventory order, and a run.Remote configured for each of them.
+func (inv *Inventory) Remotes(expr string) ([]string, map[string]*run.Remote, error) {
+	hosts, err := inv.Select(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	remotes := make(map[string]*run.Remote, len(hosts))
+	for _, host := range hosts {
+		remote, err := inv.Remote(host)
+		if err != nil {
+			return nil, nil, err
+		}
+		remotes[host] = remote
+	}
+
+	return hosts, remotes, nil
+}
+
+// Group returns a run.Group configured by config containing a
+// run.Remote for each host matched by the selection expression.
+func (inv *Inventory) Group(expr string, config run.GroupConfig) (*run.Group, error) {
+	hosts, remotes, err := inv.Remotes(expr)
+	if err != nil {
+		return nil, err
+	}
+	group := run.NewGroup(config)
+	for _, host := range hosts {
+		group.Add(host, remotes[host])
+	}
+
+	return group, nil
+}
+
+// mergeConfig sets the fields of dst to the fields of src that are
+// not zero values. Nested structs, e.g., Credentials, are merged field
+// by field.
+func mergeConfig(dst *run.RemoteConfig, src run.RemoteConfig) {
+	mergeValue(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src))
+}
+
+func mergeValue(dst reflect.Value, src reflect.Value) {
+	for i := 0; i < src.NumField(); i++ {
+		if dst.Type().Field(i).PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		f := src.Field(i)
+		switch {
+		case f.Kind() == reflect.Struct:
+			mergeValue(dst.Field(i), f)
+		case !f.IsZero():
+			dst.Field(i).Set(f)
+		}
+	}
+}
+
+// mergeVars returns dst with the variables of src added to it.
+func mergeVars(dst map[string]string, src map[string]string) map[string]string {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+
+	return dst
+}
+
+// appendUnique appends the values not already in list to it.
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if !contains(list, v) {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory_test
+
+import (
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/apatters/go-run/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInventory(t *testing.T) *inventory.Inventory {
+	inv := inventory.New()
+	require.NoError(t, inv.AddGroup(inventory.Group{
+		Name: inventory.All,
+		Config: run.RemoteConfig{
+			Credentials: run.Credentials{
+				Username:           "admin",
+				Port:               2222,
+				PrivateKeyFilename: "/dev/null",
+			},
+		},
+		Vars: map[string]string{"dc": "east", "role": "none"},
+	}))
+	require.NoError(t, inv.AddGroup(inventory.Group{
+		Name:  "web",
+		Hosts: []string{"web[01-04]"},
+		Config: run.RemoteConfig{
+			Credentials: run.Credentials{Username: "deploy"},
+		},
+		Vars: map[string]string{"role": "web"},
+	}))
+	require.NoError(t, inv.AddGroup(inventory.Group{
+		Name:  "db",
+		Hosts: []string{"db01", "db02"},
+		Vars:  map[string]string{"role": "db"},
+	}))
+	require.NoError(t, inv.AddGroup(inventory.Group{
+		Name:     "prod",
+		Hosts:    []string{"web[01-03]"},
+		Children: []string{"db"},
+		Tags:     []string{"critical"},
+	}))
+	require.NoError(t, inv.AddHost(inventory.Host{
+		Name: "web02",
+		Config: run.RemoteConfig{
+			Credentials: run.Credentials{Hostname: "10.0.0.2", Port: 22},
+		},
+		Vars: map[string]string{"dc": "west"},
+		Tags: []string{"canary"},
+	}))
+
+	return inv
+}
+
+func TestInventory_Hosts(t *testing.T) {
+	inv := newInventory(t)
+	assert.Equal(t, []string{"web01", "web02", "web03", "web04", "db01", "db02"}, inv.Hosts())
+	assert.Equal(t, []string{inventory.All, "web", "db", "prod"}, inv.Groups())
+
+	hosts, err := inv.GroupHosts("prod")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web01", "web02", "web03", "db01", "db02"}, hosts)
+
+	_, err = inv.GroupHosts("nope")
+	assert.Error(t, err)
+}
+
+func TestInventory_Config(t *testing.T) {
+	inv := newInventory(t)
+
+	config, err := inv.Config("web01")
+	require.NoError(t, err)
+	assert.Equal(t, "web01", config.Credentials.Hostname)
+	assert.Equal(t, "deploy", config.Credentials.Username)
+	assert.Equal(t, 2222, config.Credentials.Port)
+
+	config, err = inv.Config("web02")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", config.Credentials.Hostname)
+	assert.Equal(t, "deploy", config.Credentials.Username)
+	assert.Equal(t, 22, config.Credentials.Port)
+
+	config, err = inv.Config("db01")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", config.Credentials.Username)
+
+	_, err = inv.Config("nope")
+	assert.Error(t, err)
+}
+
+func TestInventory_Vars(t *testing.T) {
+	inv := newInventory(t)
+
+	vars, err := inv.Vars("web02")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"dc": "west", "role": "web"}, vars)
+
+	vars, err = inv.Vars("db02")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"dc": "east", "role": "db"}, vars)
+
+	tags, err := inv.Tags("web02")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"critical", "canary"}, tags)
+}
+
+func TestInventory_Select(t *testing.T) {
+	inv := newInventory(t)
+	for _, tc := range []struct {
+		expr  string
+		hosts []string
+	}{
+		{"all", []string{"web01", "web02", "web03", "web04", "db01", "db02"}},
+		{"*", []string{"web01", "web02", "web03", "web04", "db01", "db02"}},
+		{"web", []string{"web01", "web02", "web03", "web04"}},
+		{"web:db", []string{"web01", "web02", "web03", "web04", "db01", "db02"}},
+		{"web:&prod", []string{"web01", "web02", "web03"}},
+		{"web:&prod:!web03", []string{"web01", "web02"}},
+		{"!web03:web:&critical", []string{"web01", "web02"}},
+		{"canary", []string{"web02"}},
+		{"web[03-04],db02", []string{"web03", "web04", "db02"}},
+		{"db*", []string{"db01", "db02"}},
+		{"!prod", []string{"web04"}},
+		{"&db", []string{"db01", "db02"}},
+	} {
+		hosts, err := inv.Select(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.hosts, hosts, tc.expr)
+	}
+
+	for _, expr := range []string{"", "web::db", "web:!web99", "nope", "web[1-"} {
+		_, err := inv.Select(expr)
+		t.Logf("err = %v", err)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestInventory_Cycle(t *testing.T) {
+	inv := inventory.New()
+	require.NoError(t, inv.AddGroup(inventory.Group{Name: "a", Hosts: []string{"h1"}, Children: []string{"b"}}))
+	require.NoError(t, inv.AddGroup(inventory.Group{Name: "b", Children: []string{"a"}}))
+
+	_, err := inv.GroupHosts("a")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	_, err = inv.Config("h1")
+	assert.Error(t, err)
+}
+
+func TestInventory_Group(t *testing.T) {
+	inv := newInventory(t)
+
+	group, err := inv.Group("web:&prod", run.GroupConfig{Concurrency: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web01", "web02", "web03"}, group.Hosts())
+	assert.Equal(t, 2, group.Concurrency)
+
+	remote, ok := group.Runner("web02").(*run.Remote)
+	require.True(t, ok)
+	assert.Equal(t, "10.0.0.2", remote.Credentials.Hostname)
+	assert.Equal(t, "deploy", remote.Credentials.Username)
+	assert.Equal(t, 22, remote.Credentials.Port)
+
+	_, err = inv.Group("nope", run.GroupConfig{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apatters/go-run"
+)
+
+// LoadScript runs an Ansible dynamic inventory executable, path, with
+// the --list option and reads the JSON inventory it prints using
+// LoadJSON(). If the inventory has no "_meta" element, the executable
+// is also run with the --host option for each host to get the host's
+// variables. Commands are run using local, which must capture
+// standard out, i.e., local.Stdout must be nil. A Local created with
+// the default LocalConfig is used if local is nil.
+func LoadScript(local *run.Local, path string) (*Inventory, error) {
+	if local == nil {
+		local = run.NewLocal(run.LocalConfig{})
+	}
+	if local.Stdout != nil {
+		return nil, fmt.Errorf("inventory: dynamic inventory %s: Local.Stdout must be nil", path)
+	}
+
+	stdout, err := runScript(local, path, "--list")
+	if err != nil {
+		return nil, err
+	}
+	var inv *Inventory
+	doc, err := parseJSON(strings.NewReader(stdout))
+	if err == nil {
+		inv, err = loadTree(doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s --list)", err, path)
+	}
+	if top, ok := doc.(mapping); ok {
+		for _, item := range top {
+			if item.key == "_meta" {
+				return inv, nil
+			}
+		}
+	}
+
+	for _, host := range inv.Hosts() {
+		stdout, err := runScript(local, path, "--host", host)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := parseJSON(strings.NewReader(stdout))
+		if err != nil {
+			return nil, fmt.Errorf("%w (%s --host %s)", err, path, host)
+		}
+		vars, err := toVars(doc)
+		if err != nil {
+			return nil, fmt.Errorf("inventory: host %q: %w (%s --host %s)", host, err, path, host)
+		}
+		config, err := ansibleConfig(vars)
+		if err != nil {
+			return nil, fmt.Errorf("inventory: host %q: %w (%s --host %s)", host, err, path, host)
+		}
+		if err := inv.AddHost(Host{Name: host, Config: config, Vars: vars}); err != nil {
+			return nil, err
+		}
+	}
+
+	return inv, nil
+}
+
+// runScript runs a dynamic inventory executable and returns its
+// standard out.
+func runScript(local *run.Local, path string, args ...string) (string, error) {
+	stdout, stderr, code, err := local.Run(path, args...)
+	if err != nil {
+		return "", fmt.Errorf("inventory: dynamic inventory %s: %w", local.FormatRun(path, args...), err)
+	}
+	if code != 0 {
+		// Scripts may print secrets, such as tokens, in errors.
+		return "", fmt.Errorf("inventory: dynamic inventory %s exited with code %d: %s",
+			local.FormatRun(path, args...), code, run.Excerpt(local.Redactor.Redact(strings.TrimSpace(stderr)), run.ErrorOutputBytes))
+	}
+
+	return stdout, nil
+}
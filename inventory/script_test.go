@@ -0,0 +1,94 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package inventory_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/apatters/go-run/inventory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeScript writes an executable shell script to dir.
+func writeScript(t *testing.T, dir string, name string, script string) string {
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, []byte("#!/bin/sh\n"+script), 0755))
+
+	return filename
+}
+
+func TestLoadScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The full inventory with _meta is printed by --list.
+	script := writeScript(t, dir, "list.sh", "[ \"$1\" = --list ] || exit 1\ncat <<'EOF'\n"+jsonInventory+"\nEOF\n")
+	inv, err := inventory.LoadScript(nil, script)
+	require.NoError(t, err)
+	checkInventory(t, inv)
+
+	inv, err = inventory.Load(script)
+	require.NoError(t, err)
+	checkInventory(t, inv)
+
+	// Host variables are printed by --host.
+	script = writeScript(t, dir, "host.sh", `
+case "$1 $2" in
+"--list ")
+	echo '{"web": ["web01", "web02"], "prod": {"children": ["web"]}}' ;;
+"--host web02")
+	echo '{"ansible_host": "10.0.0.2", "ansible_port": 2222}' ;;
+"--host "*)
+	echo '{}' ;;
+*)
+	exit 1 ;;
+esac
+`)
+	inv, err = inventory.LoadScript(run.NewLocal(run.LocalConfig{}), script)
+	require.NoError(t, err)
+	hosts, err := inv.Select("prod")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web01", "web02"}, hosts)
+	config, err := inv.Config("web02")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", config.Credentials.Hostname)
+	assert.Equal(t, 2222, config.Credentials.Port)
+
+	// Failures.
+	script = writeScript(t, dir, "fail.sh", "echo 'no inventory' >&2\nexit 3\n")
+	_, err = inventory.LoadScript(nil, script)
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no inventory")
+
+	// Standard error is redacted and shortened.
+	redactor := run.NewRedactor()
+	redactor.AddLiteral("t0ken")
+	local := run.NewLocal(run.LocalConfig{Redactor: redactor})
+	script = writeScript(t, dir, "secret.sh", "echo 'bad token t0ken' >&2\nhead -c 1000 /dev/zero | tr '\\0' x >&2\nexit 1\n")
+	_, err = inventory.LoadScript(local, script)
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad token "+run.RedactedText)
+	assert.NotContains(t, err.Error(), "t0ken")
+	assert.True(t, strings.HasSuffix(err.Error(), "x..."))
+	assert.True(t, len(err.Error()) < 400)
+
+	script = writeScript(t, dir, "bad.sh", "echo '{'\n")
+	_, err = inventory.LoadScript(nil, script)
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	_, err = inventory.LoadScript(nil, filepath.Join(dir, "missing.sh"))
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
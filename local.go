@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"os/exec"
 	"regexp"
 	"strconv"
@@ -39,6 +40,18 @@ type LocalConfig struct {
 	// Stderr specifies the process's standard error. See Local
 	// for details.
 	Stderr io.Writer
+
+	// Logger receives structured log events. See Local for
+	// details.
+	Logger *slog.Logger
+
+	// LogOutputBytes is the size of the output excerpts included
+	// in log events. See Local for details.
+	LogOutputBytes int
+
+	// Redactor hides secrets in formatted commands, log events,
+	// and errors. See Local for details.
+	Redactor *Redactor
 }
 
 // Local wraps os/exec Cmd to make running external commands on the
@@ -92,6 +105,23 @@ type Local struct {
 	// be compared with ==, at most one goroutine at a time will call Write.
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Logger, if not nil, receives a structured log event when
+	// each command is started ("command start" at debug level),
+	// when it exits ("command exit" at info level, with the
+	// duration, exit code, and output sizes), and when it fails
+	// with an internal error ("command error" at error level).
+	Logger *slog.Logger
+
+	// LogOutputBytes is the maximum number of bytes of standard
+	// out and standard error included in "command exit" events.
+	// Output is not logged if LogOutputBytes is zero.
+	LogOutputBytes int
+
+	// Redactor hides secrets in the strings returned by
+	// FormatRun() and FormatShell(), in log events, and in
+	// returned errors.
+	Redactor *Redactor
 }
 
 // NewLocal is the constuctor for Local. It takes a LocalConfig
@@ -104,21 +134,41 @@ type Local struct {
 //     Stdin = nil      // Discard stdin.
 //     Stdout = nil     // Capture stdout.
 //     Stderr = nil     // Capture stderr,
+//     Logger = nil     // No logging.
+//     LogOutputBytes = 0 // Do not log output.
+//     Redactor = DefaultRedactor
 func NewLocal(config LocalConfig) *Local {
 	local := new(Local)
 	if len(config.ShellExecutable) == 0 {
 		local.ShellExecutable = DefaultShellExecutable
+	} else {
+		local.ShellExecutable = config.ShellExecutable
 	}
 	local.Env = config.Env
 	local.Dir = config.Dir
 	local.Stdin = config.Stdin
 	local.Stdout = config.Stdout
 	local.Stderr = config.Stderr
+	local.Logger = config.Logger
+	local.LogOutputBytes = config.LogOutputBytes
+	local.Redactor = config.Redactor
+	if local.Redactor == nil {
+		local.Redactor = DefaultRedactor
+	}
 
 	return local
 }
 
 func (l *Local) exec(command string, args ...string) (string, string, int, error) {
+	clog := newCommandLog(l.Logger, l.LogOutputBytes, l.Redactor.Redact, l.FormatRun(command, args...))
+	stdout, stderr, code, err := l.execCmd(clog, command, args...)
+	err = l.Redactor.RedactError(err)
+	clog.done(stdout, stderr, code, err)
+
+	return stdout, stderr, code, err
+}
+
+func (l *Local) execCmd(clog *commandLog, command string, args ...string) (string, string, int, error) {
 	var err error
 	code := 0
 	cmd := exec.Command(command, args...)
@@ -134,7 +184,7 @@ func (l *Local) exec(command string, args ...string) (string, string, int, error
 			return "", "", 0, err
 		}
 	} else {
-		cmd.Stdout = l.Stdout
+		cmd.Stdout = clog.stdoutWriter(l.Stdout)
 	}
 	var stderrPipe io.Reader
 	if l.Stderr == nil {
@@ -143,7 +193,7 @@ func (l *Local) exec(command string, args ...string) (string, string, int, error
 			return "", "", 0, err
 		}
 	} else {
-		cmd.Stderr = l.Stderr
+		cmd.Stderr = clog.stderrWriter(l.Stderr)
 	}
 
 	// Run the command.
@@ -201,7 +251,7 @@ func (l *Local) Run(cmd string, args ...string) (string, string, int, error) {
 // FormatRun returns a string representation of the what command would
 // be run using Run(). Useful for logging commands.
 func (l *Local) FormatRun(cmd string, args ...string) string {
-	return strings.TrimSpace(cmd + " " + strings.Join(args, " "))
+	return l.Redactor.Redact(strings.TrimSpace(cmd + " " + strings.Join(args, " ")))
 }
 
 // Shell runs a command in a shell. The command is passed to the shell
@@ -217,5 +267,5 @@ func (l *Local) Shell(cmd string) (string, string, int, error) {
 // FormatShell returns a string representation of the what command
 // would be run using Shell(). Useful for logging commands.
 func (l *Local) FormatShell(cmd string) string {
-	return strings.TrimSpace(fmt.Sprintf(`%s -c "%s"`, l.ShellExecutable, cmd))
+	return l.Redactor.Redact(strings.TrimSpace(fmt.Sprintf(`%s -c "%s"`, l.ShellExecutable, cmd)))
 }
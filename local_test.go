@@ -7,7 +7,10 @@ package run_test
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"regexp"
 	"strings"
@@ -15,6 +18,7 @@ import (
 
 	"github.com/apatters/go-run"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLocal_RunSuccess(t *testing.T) {
@@ -288,6 +292,18 @@ func TestLocal_ShellDir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLocal_ShellExecutable(t *testing.T) {
+	l := run.NewLocal(run.LocalConfig{ShellExecutable: "/nonexistent/sh"})
+	assert.Equal(t, "/nonexistent/sh", l.ShellExecutable)
+	assert.Equal(t, `/nonexistent/sh -c "uname"`, l.FormatShell("uname"))
+	_, _, _, err := l.Shell("uname")
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	l = run.NewLocal(run.LocalConfig{})
+	assert.Equal(t, run.DefaultShellExecutable, l.ShellExecutable)
+}
+
 func TestLocal_FormatShell(t *testing.T) {
 	l := run.NewLocal(run.LocalConfig{})
 
@@ -325,3 +341,90 @@ func TestLocal_TarFailure(t *testing.T) {
 	assert.NotZero(t, code)
 	assert.NoError(t, err)
 }
+
+func TestLocal_Logger(t *testing.T) {
+	var b bytes.Buffer
+	l := run.NewLocal(run.LocalConfig{
+		Logger: slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	_, _, code, err := l.Run("/bin/sh", "-c", "echo hello; echo oops >&2; exit 3")
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	t.Logf("log = %s", b.String())
+
+	var events []map[string]interface{}
+	dec := json.NewDecoder(&b)
+	for dec.More() {
+		var ev map[string]interface{}
+		require.NoError(t, dec.Decode(&ev))
+		events = append(events, ev)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "command start", events[0]["msg"])
+	assert.Equal(t, "/bin/sh -c echo hello; echo oops >&2; exit 3", events[0]["cmd"])
+	assert.Equal(t, "command exit", events[1]["msg"])
+	assert.EqualValues(t, 3, events[1]["exit_code"])
+	assert.EqualValues(t, 6, events[1]["stdout_bytes"])
+	assert.EqualValues(t, 5, events[1]["stderr_bytes"])
+	assert.Contains(t, events[1], "duration")
+	assert.NotContains(t, events[1], "stdout", "Output logged by default")
+}
+
+func TestLocal_LoggerOutput(t *testing.T) {
+	var b, stdout bytes.Buffer
+	l := run.NewLocal(run.LocalConfig{
+		Stdout:         &stdout,
+		Logger:         slog.New(slog.NewJSONHandler(&b, nil)),
+		LogOutputBytes: 4,
+	})
+	_, _, _, err := l.Shell("echo hello; echo oops >&2")
+	require.NoError(t, err)
+	t.Logf("log = %s", b.String())
+
+	var ev map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &ev))
+	assert.Equal(t, "command exit", ev["msg"])
+	assert.EqualValues(t, 6, ev["stdout_bytes"])
+	assert.Equal(t, "", ev["stdout"])
+	assert.Equal(t, "oops...", ev["stderr"])
+	assert.Equal(t, "hello\n", stdout.String())
+
+	b.Reset()
+	_, _, _, err = l.Run("/xyzzy")
+	require.Error(t, err)
+	require.NoError(t, json.Unmarshal(b.Bytes(), &ev))
+	assert.Equal(t, "command error", ev["msg"])
+	assert.Contains(t, ev["err"], "xyzzy")
+}
+
+func TestLocal_Redactor(t *testing.T) {
+	redactor := run.NewRedactor()
+	redactor.AddLiteral("s3cret")
+	require.NoError(t, redactor.AddFlag("--token=*"))
+	var b bytes.Buffer
+	l := run.NewLocal(run.LocalConfig{
+		Logger:         slog.New(slog.NewJSONHandler(&b, nil)),
+		LogOutputBytes: 100,
+		Redactor:       redactor,
+	})
+
+	msg := l.FormatRun("curl", "--token=abc", "https://example.com")
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, "curl --token=****** https://example.com", msg)
+
+	msg = l.FormatShell("echo s3cret")
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, `/bin/sh -c "echo ******"`, msg)
+
+	stdout, _, _, err := l.Shell("echo s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret\n", stdout, "Captured output modified")
+	t.Logf("log = %s", b.String())
+	assert.NotContains(t, b.String(), "s3cret")
+
+	_, _, _, err = l.Run("/xyzzy/s3cret")
+	t.Logf("err = %q", err)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "s3cret")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// logAttrs emits a structured log event if logger is not nil.
+func logAttrs(logger *slog.Logger, level slog.Level, msg string, attrs ...slog.Attr) {
+	if logger == nil {
+		return
+	}
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// ErrorOutputBytes is the maximum number of bytes of a command's
+// output included in error messages.
+const ErrorOutputBytes = 200
+
+// Excerpt returns at most n bytes of s, followed by "..." if s was
+// cut, e.g., to include a command's output in an error message with
+// ErrorOutputBytes as n.
+func Excerpt(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n] + "..."
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// commandLog emits the log events for a single command.
+type commandLog struct {
+	logger      *slog.Logger
+	outputBytes int
+	redact      func(string) string
+	attrs       []slog.Attr
+	start       time.Time
+	stdout      *countingWriter
+	stderr      *countingWriter
+}
+
+// newCommandLog returns a commandLog for cmdLine and emits the
+// command start event. The attrs are added to every event. Output
+// excerpts are passed through redact before they are logged.
+func newCommandLog(
+	logger *slog.Logger,
+	outputBytes int,
+	redact func(string) string,
+	cmdLine string,
+	attrs ...slog.Attr) *commandLog {
+
+	c := &commandLog{
+		logger:      logger,
+		outputBytes: outputBytes,
+		redact:      redact,
+		attrs:       append(attrs, slog.String("cmd", cmdLine)),
+		start:       time.Now(),
+	}
+	logAttrs(c.logger, slog.LevelDebug, "command start", c.attrs...)
+
+	return c
+}
+
+// stdoutWriter returns a writer that counts the bytes written to w
+// for the command exit event. It returns w unchanged if logging is
+// disabled or w is nil, i.e., output is being captured.
+func (c *commandLog) stdoutWriter(w io.Writer) io.Writer {
+	if c.logger == nil || w == nil {
+		return w
+	}
+	c.stdout = &countingWriter{w: w}
+
+	return c.stdout
+}
+
+// stderrWriter is the standard error counterpart of stdoutWriter.
+func (c *commandLog) stderrWriter(w io.Writer) io.Writer {
+	if c.logger == nil || w == nil {
+		return w
+	}
+	c.stderr = &countingWriter{w: w}
+
+	return c.stderr
+}
+
+// done emits the command exit event, or the command error event if
+// err is not nil.
+func (c *commandLog) done(stdout string, stderr string, code int, err error) {
+	if c.logger == nil {
+		return
+	}
+	attrs := append([]slog.Attr{}, c.attrs...)
+	attrs = append(attrs, slog.Duration("duration", time.Since(c.start)))
+	if err != nil {
+		attrs = append(attrs, slog.String("err", err.Error()))
+		logAttrs(c.logger, slog.LevelError, "command error", attrs...)
+		return
+	}
+	stdoutBytes := int64(len(stdout))
+	if c.stdout != nil {
+		stdoutBytes = c.stdout.n
+	}
+	stderrBytes := int64(len(stderr))
+	if c.stderr != nil {
+		stderrBytes = c.stderr.n
+	}
+	attrs = append(attrs,
+		slog.Int("exit_code", code),
+		slog.Int64("stdout_bytes", stdoutBytes),
+		slog.Int64("stderr_bytes", stderrBytes))
+	if c.outputBytes > 0 {
+		attrs = append(attrs,
+			slog.String("stdout", Excerpt(c.redact(stdout), c.outputBytes)),
+			slog.String("stderr", Excerpt(c.redact(stderr), c.outputBytes)))
+	}
+	logAttrs(c.logger, slog.LevelInfo, "command exit", attrs...)
+}
@@ -0,0 +1,274 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+// Package netconf frames NETCONF messages over SSH as described in RFC
+// 6242. A Conn wraps a stream, usually the "netconf" subsystem started
+// with run.Remote.Subsystem(), exchanges hello messages, and reads and
+// writes messages with the end-of-message framing of NETCONF 1.0 or the
+// chunked framing of NETCONF 1.1. Building and parsing RPCs is left to
+// the caller.
+package netconf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Base capabilities of the NETCONF versions.
+const (
+	Base10 = "urn:ietf:params:netconf:base:1.0"
+	Base11 = "urn:ietf:params:netconf:base:1.1"
+)
+
+// endOfMessage ends each message with the NETCONF 1.0 framing.
+const endOfMessage = "]]>]]>"
+
+// maxChunkSize is the largest chunk allowed by RFC 6242.
+const maxChunkSize = 4294967295
+
+// DefaultMaxMessageSize is the MaxMessageSize of the Conns returned by
+// NewConn().
+const DefaultMaxMessageSize = 64 << 20
+
+// Hello is a NETCONF hello message.
+type Hello struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:netconf:base:1.0 hello"`
+
+	// Capabilities lists the capability URIs of the peer.
+	Capabilities []string `xml:"capabilities>capability"`
+
+	// SessionID is the session ID assigned by the server. It is
+	// zero in the hello sent by a client.
+	SessionID uint32 `xml:"session-id,omitempty"`
+}
+
+// Has returns true if the hello lists capability.
+func (h *Hello) Has(capability string) bool {
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Conn reads and writes NETCONF messages on a stream. It starts with
+// the NETCONF 1.0 framing. A Conn is not safe for concurrent reads or
+// concurrent writes.
+type Conn struct {
+	// MaxMessageSize is the size in bytes of the largest message
+	// ReadMessage() accepts, so a peer cannot make it buffer
+	// without limit. Larger messages are framing errors. There is
+	// no limit if it is zero or negative.
+	MaxMessageSize int
+
+	rw      io.ReadWriter
+	r       *bufio.Reader
+	chunked bool
+}
+
+// NewConn returns a Conn using rw, e.g., a *run.SubsystemStream. Its
+// MaxMessageSize is DefaultMaxMessageSize.
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{MaxMessageSize: DefaultMaxMessageSize, rw: rw, r: bufio.NewReader(rw)}
+}
+
+// Chunked returns true if the NETCONF 1.1 chunked framing is used.
+func (c *Conn) Chunked() bool {
+	return c.chunked
+}
+
+// SetChunked selects the NETCONF 1.1 chunked framing if chunked is
+// true and the NETCONF 1.0 end-of-message framing otherwise. Hello()
+// selects the framing itself.
+func (c *Conn) SetChunked(chunked bool) {
+	c.chunked = chunked
+}
+
+// Hello sends a hello listing capabilities, or Base10 and Base11 if
+// none are given, and returns the hello of the server. The chunked
+// framing is used from then on if both hellos list Base11.
+func (c *Conn) Hello(capabilities ...string) (*Hello, error) {
+	if len(capabilities) == 0 {
+		capabilities = []string{Base10, Base11}
+	}
+	msg, err := xml.Marshal(&Hello{Capabilities: capabilities})
+	if err != nil {
+		return nil, fmt.Errorf("netconf: %w", err)
+	}
+	if err := c.WriteMessage(append([]byte(xml.Header), msg...)); err != nil {
+		return nil, err
+	}
+	msg, err = c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	server := new(Hello)
+	if err := xml.Unmarshal(msg, server); err != nil {
+		return nil, fmt.Errorf("netconf: invalid hello: %w", err)
+	}
+	ours := &Hello{Capabilities: capabilities}
+	c.chunked = server.Has(Base11) && ours.Has(Base11)
+
+	return server, nil
+}
+
+// WriteMessage writes a message with the current framing.
+func (c *Conn) WriteMessage(msg []byte) error {
+	var buf bytes.Buffer
+	if c.chunked {
+		if len(msg) == 0 {
+			return errors.New("netconf: cannot send an empty message with the chunked framing")
+		}
+		fmt.Fprintf(&buf, "\n#%d\n", len(msg))
+		buf.Write(msg)
+		buf.WriteString("\n##\n")
+	} else {
+		if bytes.Contains(msg, []byte(endOfMessage)) {
+			return fmt.Errorf("netconf: message contains %q", endOfMessage)
+		}
+		buf.Write(msg)
+		buf.WriteString(endOfMessage)
+	}
+	if _, err := c.rw.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("netconf: %w", err)
+	}
+
+	return nil
+}
+
+// ReadMessage reads a message with the current framing. It returns
+// io.EOF if the stream ends between messages.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	var err error
+	if c.chunked {
+		msg, err = c.readChunked()
+	} else {
+		msg, err = c.readEndOfMessage()
+	}
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("netconf: %w", err)
+	}
+
+	return msg, nil
+}
+
+// readEndOfMessage reads up to the end-of-message marker. White space
+// around the message, such as the newline servers send after the
+// marker, is removed.
+func (c *Conn) readEndOfMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		chunk, err := c.r.ReadSlice('>')
+		msg = append(msg, chunk...)
+		if c.MaxMessageSize > 0 && len(msg) > c.MaxMessageSize+len(endOfMessage) {
+			return nil, c.tooLarge("end-of-message")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(bytes.TrimSpace(msg)) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if bytes.HasSuffix(msg, []byte(endOfMessage)) {
+			return bytes.TrimSpace(msg[:len(msg)-len(endOfMessage)]), nil
+		}
+	}
+}
+
+// readChunked reads chunks up to the end-of-chunks marker.
+func (c *Conn) readChunked() ([]byte, error) {
+	var msg bytes.Buffer
+	for first := true; ; first = false {
+		if err := c.expect("\n#"); err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if b == '#' {
+			if first {
+				return nil, errors.New("malformed chunked framing: message without chunks")
+			}
+			if err := c.expect("\n"); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			return msg.Bytes(), nil
+		}
+		c.r.UnreadByte() // nolint
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size, err := strconv.ParseUint(line[:len(line)-1], 10, 32)
+		if err != nil || size == 0 || size > maxChunkSize || line[0] == '0' {
+			return nil, fmt.Errorf("malformed chunked framing: invalid chunk size %q", line[:len(line)-1])
+		}
+		if c.MaxMessageSize > 0 && uint64(msg.Len())+size > uint64(c.MaxMessageSize) {
+			return nil, c.tooLarge("chunked")
+		}
+		if _, err := io.CopyN(&msg, c.r, int64(size)); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+// expect reads s or returns an error.
+func (c *Conn) expect(s string) error {
+	for i := 0; i < len(s); i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			if i > 0 {
+				return unexpectedEOF(err)
+			}
+			return err
+		}
+		if b != s[i] {
+			return fmt.Errorf("malformed chunked framing: expected %q", s)
+		}
+	}
+
+	return nil
+}
+
+// tooLarge returns the error reported for messages larger than
+// MaxMessageSize.
+func (c *Conn) tooLarge(framing string) error {
+	return fmt.Errorf("malformed %s framing: message larger than MaxMessageSize of %d bytes", framing, c.MaxMessageSize)
+}
+
+// unexpectedEOF turns io.EOF into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// Close closes the stream if it is an io.Closer.
+func (c *Conn) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package netconf_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run/netconf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rpc = `<rpc message-id="101" xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><get/></rpc>`
+
+func TestConn_Framing(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		var buf bytes.Buffer
+		c := netconf.NewConn(&buf)
+		c.SetChunked(chunked)
+		assert.Equal(t, chunked, c.Chunked())
+		require.NoError(t, c.WriteMessage([]byte(rpc)))
+		require.NoError(t, c.WriteMessage([]byte("<close-session/>")))
+		if chunked {
+			assert.True(t, strings.HasPrefix(buf.String(), "\n#"))
+			assert.True(t, strings.HasSuffix(buf.String(), "\n##\n"))
+		} else {
+			assert.True(t, strings.HasSuffix(buf.String(), "]]>]]>"))
+		}
+		msg, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, rpc, string(msg))
+		msg, err = c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "<close-session/>", string(msg))
+		_, err = c.ReadMessage()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestConn_ReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked bool
+		input   string
+		msg     string
+		err     error
+	}{
+		{"end of message", false, "<ok/>]]>]]>", "<ok/>", nil},
+		{"white space", false, "\n <ok/>\n]]>]]>\n", "<ok/>", nil},
+		{"greater than", false, "<a>x > y</a>]]>]]>", "<a>x > y</a>", nil},
+		{"truncated", false, "<ok/>]]>", "", io.ErrUnexpectedEOF},
+		{"chunks", true, "\n#4\n<rpc\n#17\n message-id=\"1\"/>\n##\n", "<rpc message-id=\"1\"/>", nil},
+		{"newline in chunk", true, "\n#5\n<a>\n\n\n##\n", "<a>\n\n", nil},
+		{"zero size", true, "\n#0\n\n##\n", "", nil},
+		{"leading zero", true, "\n#04\n<ok/\n##\n", "", nil},
+		{"bad size", true, "\n#x\n<ok/>\n##\n", "", nil},
+		{"no chunks", true, "\n##\n", "", nil},
+		{"missing header", true, "<ok/>", "", nil},
+		{"short chunk", true, "\n#10\n<ok/>", "", io.ErrUnexpectedEOF},
+		{"missing end", true, "\n#5\n<ok/>", "", io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := netconf.NewConn(bytes.NewBufferString(test.input))
+			c.SetChunked(test.chunked)
+			msg, err := c.ReadMessage()
+			if test.msg != "" {
+				require.NoError(t, err)
+				assert.Equal(t, test.msg, string(msg))
+				return
+			}
+			t.Logf("err = %v", err)
+			require.Error(t, err)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+			}
+		})
+	}
+}
+
+func TestConn_MaxMessageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked bool
+		input   string
+	}{
+		{"end of message", false, "<data>" + strings.Repeat("x", 20) + "</data>]]>]]>"},
+		{"chunk", true, "\n#4294967295\n<data>"},
+		{"chunks", true, "\n#10\n<data>xxxx\n#10\nxxxxxxxxxx\n#7\n</data>\n##\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := netconf.NewConn(bytes.NewBufferString(test.input))
+			assert.Equal(t, netconf.DefaultMaxMessageSize, c.MaxMessageSize)
+			c.SetChunked(test.chunked)
+			c.MaxMessageSize = 16
+			_, err := c.ReadMessage()
+			t.Logf("err = %v", err)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "message larger than MaxMessageSize of 16 bytes")
+		})
+	}
+
+	// There is no limit if MaxMessageSize is zero.
+	c := netconf.NewConn(bytes.NewBufferString("\n#10\n<data>xxxx\n#10\nxxxxxxxxxx\n#7\n</data>\n##\n"))
+	c.SetChunked(true)
+	c.MaxMessageSize = 0
+	msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "<data>"+strings.Repeat("x", 14)+"</data>", string(msg))
+}
+
+func TestConn_WriteMessageErrors(t *testing.T) {
+	var buf bytes.Buffer
+	c := netconf.NewConn(&buf)
+	err := c.WriteMessage([]byte("<a>]]>]]></a>"))
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	c.SetChunked(true)
+	err = c.WriteMessage(nil)
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+// serveHello answers a client hello on conn with a hello listing
+// capabilities and returns the client's hello.
+func serveHello(t *testing.T, conn net.Conn, capabilities ...string) <-chan *netconf.Hello {
+	hellos := make(chan *netconf.Hello, 1)
+	go func() {
+		defer close(hellos)
+		server := netconf.NewConn(conn)
+		msg, err := server.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		client := new(netconf.Hello)
+		assert.NoError(t, xml.Unmarshal(msg, client))
+		reply, err := xml.Marshal(&netconf.Hello{Capabilities: capabilities, SessionID: 4})
+		assert.NoError(t, err)
+		assert.NoError(t, server.WriteMessage(reply))
+		hellos <- client
+	}()
+
+	return hellos
+}
+
+func TestConn_Hello(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  []string
+		server  []string
+		chunked bool
+	}{
+		{"both 1.1", nil, []string{netconf.Base10, netconf.Base11}, true},
+		{"server 1.0", nil, []string{netconf.Base10}, false},
+		{"client 1.0", []string{netconf.Base10}, []string{netconf.Base10, netconf.Base11}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientEnd, serverEnd := net.Pipe()
+			defer clientEnd.Close() // nolint
+			defer serverEnd.Close() // nolint
+			sent := serveHello(t, serverEnd, test.server...)
+			c := netconf.NewConn(clientEnd)
+			hello, err := c.Hello(test.client...)
+			require.NoError(t, err)
+			assert.Equal(t, test.server, hello.Capabilities)
+			assert.Equal(t, uint32(4), hello.SessionID)
+			assert.Equal(t, test.chunked, c.Chunked())
+			want := test.client
+			if want == nil {
+				want = []string{netconf.Base10, netconf.Base11}
+			}
+			assert.Equal(t, want, (<-sent).Capabilities)
+		})
+	}
+
+	// A server that does not send a hello.
+	clientEnd, serverEnd := net.Pipe()
+	defer clientEnd.Close() // nolint
+	go func() {
+		server := netconf.NewConn(serverEnd)
+		server.ReadMessage()                        // nolint
+		server.WriteMessage([]byte("<rpc-reply/>")) // nolint
+		serverEnd.Close()                           // nolint
+	}()
+	_, err := netconf.NewConn(clientEnd).Hello()
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
@@ -0,0 +1,332 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Dialer opens the connection to an SSH server. *net.Dialer,
+// *SOCKS5Dialer, *HTTPConnectDialer, and *Remote implement it, so a
+// Remote can reach its host through a proxy or through another Remote
+// used as a jump host.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, addr string) (net.Conn, error)
+}
+
+// DialerFunc is a function used as a Dialer. It can return a
+// connection the caller already holds, e.g., a serial bridge or one
+// end of net.Pipe() in tests:
+//
+//     config.Dialer = run.DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+//         return conn, nil
+//     })
+type DialerFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+// DialContext calls f.
+func (f DialerFunc) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// ProxyConfig configures a proxy dialer.
+type ProxyConfig struct {
+	// Address is the host:port of the proxy.
+	Address string
+
+	// Username and Password authenticate with the proxy if
+	// Username is not empty.
+	Username string
+	Password string
+
+	// Dialer connects to the proxy. If it is nil, a net.Dialer is
+	// used.
+	Dialer Dialer
+}
+
+// proxyDialer returns the dialer connecting to the proxy.
+func (c *ProxyConfig) proxyDialer() Dialer {
+	if c.Dialer == nil {
+		return &net.Dialer{}
+	}
+
+	return c.Dialer
+}
+
+// SOCKS5Dialer connects through a SOCKS5 proxy (RFC 1928). Host names
+// are resolved by the proxy.
+type SOCKS5Dialer struct {
+	config ProxyConfig
+}
+
+// NewSOCKS5Dialer is the constructor for SOCKS5Dialer. Username and
+// password authentication (RFC 1929) is offered if config.Username is
+// not empty.
+func NewSOCKS5Dialer(config ProxyConfig) (*SOCKS5Dialer, error) {
+	if config.Address == "" {
+		return nil, errors.New("run: SOCKS5 proxy address is not set")
+	}
+
+	return &SOCKS5Dialer{config: config}, nil
+}
+
+// DialContext connects to addr through the proxy. Only the "tcp"
+// network is supported.
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("run: cannot dial %s %s through SOCKS5 proxy %s: unsupported network", network, addr, d.config.Address)
+	}
+	conn, err := d.config.proxyDialer().DialContext(ctx, "tcp", d.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot connect to SOCKS5 proxy %s: %w", d.config.Address, err)
+	}
+	if err := proxyHandshake(ctx, conn, func() error { return d.handshake(conn, addr) }); err != nil {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("run: cannot dial %s through SOCKS5 proxy %s: %w", addr, d.config.Address, err)
+	}
+
+	return conn, nil
+}
+
+// handshake authenticates and sends a CONNECT request for addr.
+func (d *SOCKS5Dialer) handshake(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// Authentication method.
+	methods := []byte{socksNoAuth}
+	if d.config.Username != "" {
+		methods = append(methods, socksUserPass)
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	buf := make([]byte, 2, 256)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[0] != socksVersion {
+		return fmt.Errorf("unsupported version %d", buf[0])
+	}
+	switch buf[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	// Request.
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		req = append(req, socksAddrDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socksAddrIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socksAddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply.
+	buf = buf[:4]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if buf[1] != socksSucceeded {
+		return fmt.Errorf("request failed: %s", socksReplyText(buf[1]))
+	}
+	var skip int
+	switch buf[3] {
+	case socksAddrIPv4:
+		skip = net.IPv4len
+	case socksAddrIPv6:
+		skip = net.IPv6len
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("unsupported address type %d", buf[3])
+	}
+	// Bound address and port, which are not needed.
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+
+	return err
+}
+
+// authenticate sends the user name and password (RFC 1929).
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.config.Username) > 255 || len(d.config.Password) > 255 {
+		return errors.New("user name or password is too long")
+	}
+	req := []byte{socksUserPassVersion, byte(len(d.config.Username))}
+	req = append(req, d.config.Username...)
+	req = append(req, byte(len(d.config.Password)))
+	req = append(req, d.config.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+
+	return nil
+}
+
+// socksReplyText describes a SOCKS5 reply code.
+func socksReplyText(reply byte) string {
+	switch reply {
+	case socksGeneralFailure:
+		return "general failure"
+	case socksNotAllowed:
+		return "connection not allowed by ruleset"
+	case 0x03:
+		return "network unreachable"
+	case 0x04:
+		return "host unreachable"
+	case socksRefused:
+		return "connection refused"
+	case 0x06:
+		return "TTL expired"
+	case socksCmdNotSupported:
+		return "command not supported"
+	case socksAddrNotSupport:
+		return "address type not supported"
+	}
+
+	return fmt.Sprintf("reply code %d", reply)
+}
+
+// HTTPConnectDialer connects through an HTTP proxy with the CONNECT
+// method.
+type HTTPConnectDialer struct {
+	config ProxyConfig
+}
+
+// NewHTTPConnectDialer is the constructor for HTTPConnectDialer. Basic
+// authentication is used if config.Username is not empty.
+func NewHTTPConnectDialer(config ProxyConfig) (*HTTPConnectDialer, error) {
+	if config.Address == "" {
+		return nil, errors.New("run: HTTP proxy address is not set")
+	}
+
+	return &HTTPConnectDialer{config: config}, nil
+}
+
+// DialContext connects to addr through the proxy. Only the "tcp"
+// network is supported.
+func (d *HTTPConnectDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, fmt.Errorf("run: cannot dial %s %s through HTTP proxy %s: unsupported network", network, addr, d.config.Address)
+	}
+	conn, err := d.config.proxyDialer().DialContext(ctx, "tcp", d.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot connect to HTTP proxy %s: %w", d.config.Address, err)
+	}
+	var proxied net.Conn
+	err = proxyHandshake(ctx, conn, func() error {
+		var err error
+		proxied, err = d.handshake(conn, addr)
+		return err
+	})
+	if err != nil {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("run: cannot dial %s through HTTP proxy %s: %w", addr, d.config.Address, err)
+	}
+
+	return proxied, nil
+}
+
+// handshake sends the CONNECT request for addr and reads the
+// response. The returned connection includes any data the proxy sent
+// after the response.
+func (d *HTTPConnectDialer) handshake(conn net.Conn, addr string) (net.Conn, error) {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if d.config.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.config.Username + ":" + d.config.Password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy responded %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// proxyHandshake runs handshake on conn. The handshake is interrupted
+// by closing conn if ctx is done, which, unlike deadlines, works for
+// every connection, e.g., those dialed through a jump host.
+func proxyHandshake(ctx context.Context, conn net.Conn, handshake func() error) error {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.Close() // nolint
+		case <-done:
+		}
+	}()
+	err := handshake()
+	close(done)
+	<-stopped
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
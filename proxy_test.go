@@ -0,0 +1,335 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestProxy listens on the loopback interface and serves each
+// connection with handle. It is stopped when the test ends.
+func startTestProxy(t *testing.T, handle func(conn net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startSOCKS5Proxy starts a SOCKS5 proxy requiring the user name and
+// password if username is not empty.
+func startSOCKS5Proxy(t *testing.T, username string, password string) string {
+	return startTestProxy(t, func(conn net.Conn) {
+		defer conn.Close() // nolint
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		methods := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+		if username == "" {
+			conn.Write([]byte{5, 0}) // nolint
+		} else {
+			conn.Write([]byte{5, 2}) // nolint
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			user := make([]byte, buf[1])
+			io.ReadFull(conn, user)      // nolint
+			io.ReadFull(conn, buf[:1])   // nolint
+			pass := make([]byte, buf[0]) // nolint
+			io.ReadFull(conn, pass)      // nolint
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1}) // nolint
+				return
+			}
+			conn.Write([]byte{1, 0}) // nolint
+		}
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			ip := make(net.IP, 4)
+			io.ReadFull(conn, ip) // nolint
+			host = ip.String()
+		case 3:
+			io.ReadFull(conn, buf[:1]) // nolint
+			name := make([]byte, buf[0])
+			io.ReadFull(conn, name) // nolint
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port) // nolint
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) // nolint
+			return
+		}
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // nolint
+		proxy(conn, target)
+	})
+}
+
+// startHTTPProxy starts an HTTP CONNECT proxy requiring the user name
+// and password if username is not empty.
+func startHTTPProxy(t *testing.T, username string, password string) string {
+	return startTestProxy(t, func(conn net.Conn) {
+		defer conn.Close() // nolint
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n") // nolint
+			return
+		}
+		if username != "" {
+			want := "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+			if req.Header.Get("Proxy-Authorization") != want {
+				io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n") // nolint
+				return
+			}
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n") // nolint
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n") // nolint
+		proxy(conn, target)
+	})
+}
+
+func TestRemote_Dialer(t *testing.T) {
+	jump, _ := newPasswordRemote(t)
+	socks, err := run.NewSOCKS5Dialer(run.ProxyConfig{Address: startSOCKS5Proxy(t, "", "")})
+	require.NoError(t, err)
+	socksAuth, err := run.NewSOCKS5Dialer(run.ProxyConfig{
+		Address:  startSOCKS5Proxy(t, "proxy", "pw"),
+		Username: "proxy",
+		Password: "pw",
+	})
+	require.NoError(t, err)
+	httpProxy, err := run.NewHTTPConnectDialer(run.ProxyConfig{Address: startHTTPProxy(t, "", "")})
+	require.NoError(t, err)
+	httpAuth, err := run.NewHTTPConnectDialer(run.ProxyConfig{
+		Address:  startHTTPProxy(t, "proxy", "pw"),
+		Username: "proxy",
+		Password: "pw",
+	})
+	require.NoError(t, err)
+
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	var dialed string
+	conn := run.DialerFunc(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		dialed = addr
+		return net.Dial(network, addr)
+	})
+
+	tests := []struct {
+		name   string
+		dialer run.Dialer
+	}{
+		{"SOCKS5", socks},
+		{"SOCKS5 with authentication", socksAuth},
+		{"HTTP CONNECT", httpProxy},
+		{"HTTP CONNECT with authentication", httpAuth},
+		{"jump host", jump},
+		{"DialerFunc", conn},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds := server.Credentials("deploy")
+			creds.Password = "s3cret"
+			r, err := run.NewRemote(run.RemoteConfig{Credentials: creds, Dialer: test.dialer})
+			require.NoError(t, err)
+			stdout, _, code, err := r.Run("echo", "through")
+			require.NoError(t, err)
+			assert.Equal(t, 0, code)
+			assert.Equal(t, "through\n", stdout)
+		})
+	}
+	assert.Equal(t, net.JoinHostPort(server.Host, strconv.Itoa(server.Port)), dialed)
+}
+
+func TestRemote_DialerErrors(t *testing.T) {
+	_, err := run.NewSOCKS5Dialer(run.ProxyConfig{})
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+	_, err = run.NewHTTPConnectDialer(run.ProxyConfig{})
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	socksAuth, err := run.NewSOCKS5Dialer(run.ProxyConfig{
+		Address:  startSOCKS5Proxy(t, "proxy", "pw"),
+		Username: "proxy",
+		Password: "wrong",
+	})
+	require.NoError(t, err)
+	httpAuth, err := run.NewHTTPConnectDialer(run.ProxyConfig{Address: startHTTPProxy(t, "proxy", "pw")})
+	require.NoError(t, err)
+	socks, err := run.NewSOCKS5Dialer(run.ProxyConfig{Address: startSOCKS5Proxy(t, "", "")})
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close() // nolint
+
+	tests := []struct {
+		name   string
+		dialer run.Dialer
+		port   int
+		msg    string
+	}{
+		{"SOCKS5 authentication", socksAuth, server.Port, "authentication failed"},
+		{"HTTP authentication", httpAuth, server.Port, "407 Proxy Authentication Required"},
+		{"SOCKS5 target refused", socks, refusedPort, "connection refused"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds := server.Credentials("deploy")
+			creds.Port = test.port
+			creds.Password = "s3cret"
+			r, err := run.NewRemote(run.RemoteConfig{Credentials: creds, Dialer: test.dialer})
+			require.NoError(t, err)
+			_, _, _, err = r.Run("true")
+			t.Logf("err = %v", err)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.msg)
+			assert.True(t, errors.Is(err, run.ErrConnectFailed))
+		})
+	}
+}
+
+func TestRemote_DialerTimeout(t *testing.T) {
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials:    creds,
+		ConnectTimeout: 50 * time.Millisecond,
+		Dialer: run.DialerFunc(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectTimeout))
+	var cerr *run.ConnectionError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "dial", cerr.Op)
+}
+
+// noDeadlineConn is a connection that does not support deadlines, like
+// connections dialed through a jump host.
+type noDeadlineConn struct {
+	net.Conn
+}
+
+func (c *noDeadlineConn) SetDeadline(t time.Time) error {
+	return errors.New("deadlines not supported")
+}
+
+func (c *noDeadlineConn) SetReadDeadline(t time.Time) error {
+	return errors.New("deadlines not supported")
+}
+
+func (c *noDeadlineConn) SetWriteDeadline(t time.Time) error {
+	return errors.New("deadlines not supported")
+}
+
+func TestRemote_HandshakeTimeoutWithoutDeadlines(t *testing.T) {
+	// A listener that never speaks SSH.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { silent.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint
+		}
+	}()
+
+	creds := run.Credentials{
+		Hostname: "127.0.0.1",
+		Port:     silent.Addr().(*net.TCPAddr).Port,
+		Username: "deploy",
+		Password: "s3cret",
+	}
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials:      creds,
+		HandshakeTimeout: 100 * time.Millisecond,
+		Dialer: run.DialerFunc(func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &noDeadlineConn{conn}, nil
+		}),
+	})
+	require.NoError(t, err)
+	start := time.Now()
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectTimeout))
+	var cerr *run.ConnectionError
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "handshake", cerr.Op)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// The same holds for proxy handshakes.
+	socks, err := run.NewSOCKS5Dialer(run.ProxyConfig{
+		Address: silent.Addr().String(),
+		Dialer:  r.Dialer,
+	})
+	require.NoError(t, err)
+	r, err = run.NewRemote(run.RemoteConfig{
+		Credentials:    creds,
+		ConnectTimeout: 100 * time.Millisecond,
+		Dialer:         socks,
+	})
+	require.NoError(t, err)
+	start = time.Now()
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectTimeout))
+	require.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "dial", cerr.Op)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
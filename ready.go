@@ -0,0 +1,242 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The stages of Remote.WaitReady().
+const (
+	// ReadyStageTCP waits for the host to accept TCP connections
+	// on the SSH port.
+	ReadyStageTCP = "tcp"
+
+	// ReadyStageSSH waits for an SSH handshake, including
+	// authentication, to succeed.
+	ReadyStageSSH = "ssh"
+
+	// ReadyStageCommand waits for WaitReadyConfig.Command to exit
+	// with 0.
+	ReadyStageCommand = "command"
+)
+
+// WaitReadyConfig configures Remote.WaitReady().
+type WaitReadyConfig struct {
+	// Command is a shell command run once the host accepts SSH
+	// connections, e.g., "systemctl is-system-running". It is run
+	// by ShellExecutable, like Remote.Shell(), and logged like
+	// other commands. The host is ready when it exits with 0. If
+	// Command is empty, the host is ready once an SSH handshake
+	// succeeds.
+	Command string
+
+	// Backoff is the delay between polls within a stage. It
+	// starts again from Backoff.Initial at each stage. Unset
+	// fields use their default values, e.g., DefaultMaxBackoff.
+	Backoff Backoff
+}
+
+// ReadyStage reports how long one stage of Remote.WaitReady() took.
+type ReadyStage struct {
+	// Name is the name of the stage, e.g., ReadyStageTCP.
+	Name string
+
+	// Attempts is the number of polls made in the stage.
+	Attempts int
+
+	// Duration is the time spent in the stage.
+	Duration time.Duration
+
+	// Err is the error of the last failed poll, or nil if the
+	// stage succeeded on its first poll.
+	Err error
+
+	// Done is true if the stage succeeded.
+	Done bool
+}
+
+// ReadyResult reports the progress of Remote.WaitReady().
+type ReadyResult struct {
+	// Stages are the stages that were started, in order. The
+	// last one did not succeed if WaitReady() failed.
+	Stages []ReadyStage
+
+	// Duration is the total time spent waiting.
+	Duration time.Duration
+}
+
+// Stage returns the named stage, or nil if it was not started.
+func (r *ReadyResult) Stage(name string) *ReadyStage {
+	for i := range r.Stages {
+		if r.Stages[i].Name == name {
+			return &r.Stages[i]
+		}
+	}
+
+	return nil
+}
+
+// WaitReady polls the host until it is ready, e.g., after it was
+// provisioned or rebooted. It waits in stages: for the SSH port to
+// accept TCP connections, for an SSH handshake to succeed, and, if
+// config.Command is set, for the command to exit with 0. Each stage
+// is polled with config.Backoff delays until it succeeds or ctx is
+// done. Polls use the ConnectTimeout and HandshakeTimeout of the
+// Remote and connections of their own; the shared connection is not
+// used or opened. A poll still in progress when ctx is done, e.g., a
+// hanging command, is abandoned and its connection closed.
+//
+// The returned ReadyResult reports the attempts and time spent in each
+// stage, also if WaitReady() fails. It fails with an error wrapping
+// ctx.Err() and describing the last failed poll if ctx is done first,
+// and immediately if authentication fails, because that is not
+// expected to go away:
+//
+//     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+//     defer cancel()
+//     res, err := remote.WaitReady(ctx, run.WaitReadyConfig{
+//         Command: "systemctl is-system-running",
+//     })
+func (r *Remote) WaitReady(ctx context.Context, config WaitReadyConfig) (*ReadyResult, error) {
+	start := time.Now()
+	res := &ReadyResult{}
+	type stage struct {
+		name string
+		poll func(ctx context.Context) error
+	}
+	stages := []stage{
+		{ReadyStageTCP, r.pollTCP},
+		{ReadyStageSSH, r.pollSSH},
+	}
+	if config.Command != "" {
+		stages = append(stages, stage{ReadyStageCommand, func(ctx context.Context) error {
+			return r.pollCommand(ctx, config.Command)
+		}})
+	}
+	for _, st := range stages {
+		res.Stages = append(res.Stages, ReadyStage{Name: st.name})
+		s := &res.Stages[len(res.Stages)-1]
+		err := r.waitStage(ctx, s, st.poll, config.Backoff)
+		res.Duration = time.Since(start)
+		if err != nil {
+			logAttrs(r.Logger, slog.LevelWarn, "ssh host not ready",
+				append(r.logAttrs(),
+					slog.String("stage", s.Name),
+					slog.Int("attempts", s.Attempts),
+					slog.Duration("duration", res.Duration),
+					slog.String("err", err.Error()))...)
+			return res, r.redactError(err)
+		}
+	}
+	logAttrs(r.Logger, slog.LevelInfo, "ssh host ready",
+		append(r.logAttrs(), slog.Duration("duration", res.Duration))...)
+
+	return res, nil
+}
+
+// waitStage polls a stage of WaitReady() until it succeeds or ctx is
+// done.
+func (r *Remote) waitStage(ctx context.Context, s *ReadyStage, poll func(ctx context.Context) error, backoff Backoff) error {
+	start := time.Now()
+	defer func() { s.Duration = time.Since(start) }()
+	for {
+		if err := ctx.Err(); err != nil {
+			return r.notReady(s, err)
+		}
+		s.Attempts++
+		err := poll(ctx)
+		if err == nil {
+			s.Done = true
+			logAttrs(r.Logger, slog.LevelDebug, "ssh ready stage done",
+				append(r.logAttrs(),
+					slog.String("stage", s.Name),
+					slog.Int("attempts", s.Attempts),
+					slog.Duration("duration", time.Since(start)))...)
+			return nil
+		}
+		s.Err = err
+		if errors.Is(err, ErrAuthFailed) {
+			return fmt.Errorf("run: %s is not ready at stage %s: %w", r.Credentials.Hostname, s.Name, err)
+		}
+		timer := time.NewTimer(backoff.Delay(s.Attempts))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return r.notReady(s, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// notReady returns the error WaitReady() fails with when ctx is done
+// during stage s.
+func (r *Remote) notReady(s *ReadyStage, ctxErr error) error {
+	if s.Err == nil {
+		return fmt.Errorf("run: %s is not ready at stage %s: %w", r.Credentials.Hostname, s.Name, ctxErr)
+	}
+
+	return fmt.Errorf("run: %s is not ready at stage %s: %w (last error: %v)", r.Credentials.Hostname, s.Name, ctxErr, s.Err)
+}
+
+// pollTCP connects to the SSH port and closes the connection.
+func (r *Remote) pollTCP(ctx context.Context) error {
+	if r.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.ConnectTimeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(r.Credentials.Hostname, strconv.Itoa(r.Credentials.Port))
+	conn, err := r.Dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		reason := ErrConnectFailed
+		if isTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+			reason = ErrConnectTimeout
+		}
+		return r.connError("dial", reason, err)
+	}
+
+	return conn.Close()
+}
+
+// pollSSH connects to the host and closes the connection.
+func (r *Remote) pollSSH(ctx context.Context) error {
+	client, err := r.connect(ctx)
+	if err != nil {
+		return err
+	}
+	client.Close() // nolint
+
+	return nil
+}
+
+// pollCommand runs cmd in a shell on a connection of its own and
+// returns an error unless it exits with 0.
+func (r *Remote) pollCommand(ctx context.Context, cmd string) error {
+	cmdLine := fmt.Sprintf(`%s -c "%s"`, r.ShellExecutable, cmd)
+	stdout, stderr, code, err := r.execContext(ctx, true, nil, nil, nil, cmdLine)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		output := strings.TrimSpace(stderr)
+		if output == "" {
+			output = strings.TrimSpace(stdout)
+		}
+		if output != "" {
+			return fmt.Errorf("run: %q failed on %s: exit code %d: %s", cmd, r.Credentials.Hostname, code, Excerpt(output, ErrorOutputBytes))
+		}
+		return fmt.Errorf("run: %q failed on %s: exit code %d", cmd, r.Credentials.Hostname, code)
+	}
+
+	return nil
+}
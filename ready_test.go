@@ -0,0 +1,163 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemote_WaitReady(t *testing.T) {
+	r, _ := newPasswordRemote(t)
+	backoff := run.Backoff{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+
+	// The port is closed for the first two polls.
+	var mu sync.Mutex
+	dials := 0
+	r.Dialer = run.DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dials++
+		n := dials
+		mu.Unlock()
+		if n <= 2 {
+			return nil, syscall.ECONNREFUSED
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+	// The command succeeds once the host has "booted".
+	ready := filepath.Join(t.TempDir(), "ready")
+	time.AfterFunc(150*time.Millisecond, func() {
+		ioutil.WriteFile(ready, nil, 0600) // nolint
+	})
+	res, err := r.WaitReady(context.Background(), run.WaitReadyConfig{
+		Command: "test -e " + ready + " || { echo booting >&2; false; }",
+		Backoff: backoff,
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Stages, 3)
+	assert.True(t, res.Duration >= 150*time.Millisecond)
+
+	tcp := res.Stage(run.ReadyStageTCP)
+	require.NotNil(t, tcp)
+	assert.True(t, tcp.Done)
+	assert.Equal(t, 3, tcp.Attempts)
+	assert.True(t, errors.Is(tcp.Err, run.ErrConnectFailed))
+
+	ssh := res.Stage(run.ReadyStageSSH)
+	require.NotNil(t, ssh)
+	assert.True(t, ssh.Done)
+	assert.Equal(t, 1, ssh.Attempts)
+	assert.NoError(t, ssh.Err)
+
+	cmd := res.Stage(run.ReadyStageCommand)
+	require.NotNil(t, cmd)
+	assert.True(t, cmd.Done)
+	assert.True(t, cmd.Attempts > 1)
+	require.Error(t, cmd.Err)
+	assert.Contains(t, cmd.Err.Error(), "booting")
+	assert.True(t, res.Duration >= tcp.Duration+ssh.Duration+cmd.Duration)
+
+	// Without a command, the host is ready once SSH is.
+	r.Dialer = &net.Dialer{}
+	res, err = r.WaitReady(context.Background(), run.WaitReadyConfig{})
+	require.NoError(t, err)
+	require.Len(t, res.Stages, 2)
+	assert.Nil(t, res.Stage(run.ReadyStageCommand))
+}
+
+func TestRemote_WaitReadyErrors(t *testing.T) {
+	backoff := run.Backoff{Initial: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+
+	// A command that never succeeds.
+	r, server := newPasswordRemote(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	res, err := r.WaitReady(ctx, run.WaitReadyConfig{Command: "false", Backoff: backoff})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "run: 127.0.0.1 is not ready at stage command")
+	require.Len(t, res.Stages, 3)
+	assert.False(t, res.Stage(run.ReadyStageCommand).Done)
+	assert.True(t, res.Stage(run.ReadyStageCommand).Attempts > 1)
+
+	// A command that hangs is abandoned when ctx is done.
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err = r.WaitReady(ctx, run.WaitReadyConfig{Command: "sleep 10", Backoff: backoff})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "at stage command")
+	assert.Equal(t, 1, res.Stage(run.ReadyStageCommand).Attempts)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// Authentication failures are not retried.
+	creds := server.Credentials("deploy")
+	creds.Password = "wrong"
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	res, err = r.WaitReady(context.Background(), run.WaitReadyConfig{Backoff: backoff})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrAuthFailed))
+	assert.Equal(t, 1, res.Stage(run.ReadyStageSSH).Attempts)
+
+	// A host that stays down.
+	server.Close()
+	creds.Password = "s3cret"
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res, err = r.WaitReady(ctx, run.WaitReadyConfig{Backoff: backoff})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "at stage tcp")
+	assert.Contains(t, err.Error(), "last error: run: connection to deploy@127.0.0.1 failed")
+	require.Len(t, res.Stages, 1)
+	assert.False(t, res.Stages[0].Done)
+
+	// A host that accepts connections but never completes the SSH
+	// handshake is abandoned when ctx is done, well before
+	// HandshakeTimeout.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { silent.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() // nolint
+		}
+	}()
+	creds.Port = silent.Addr().(*net.TCPAddr).Port
+	r, err = run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	res, err = r.WaitReady(ctx, run.WaitReadyConfig{Backoff: backoff})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "at stage ssh")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
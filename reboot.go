@@ -0,0 +1,295 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRebootCommand is the command used by Remote.Reboot()
+	// if RebootConfig.Command is not set.
+	DefaultRebootCommand = "reboot"
+
+	// DefaultBootIDCommand is the command used to read the boot ID
+	// if RebootConfig.BootIDCommand is not set. The Linux kernel
+	// generates a new boot ID each time it boots.
+	DefaultBootIDCommand = "cat /proc/sys/kernel/random/boot_id"
+
+	// DefaultRebootDownTimeout is how long Remote.Reboot() waits
+	// for the host to go down if RebootConfig.DownTimeout is not
+	// set.
+	DefaultRebootDownTimeout = 5 * time.Minute
+
+	// DefaultRebootPollTimeout limits each poll of the boot ID if
+	// RebootConfig.PollTimeout is not set.
+	DefaultRebootPollTimeout = time.Minute
+)
+
+// ErrNotRebooted is returned by Remote.Reboot() if the host did not go
+// down or came back with the same boot ID.
+var ErrNotRebooted = errors.New("run: host did not reboot")
+
+// RebootConfig configures Remote.Reboot().
+type RebootConfig struct {
+	// Command reboots the host, e.g., "sudo systemctl reboot". It
+	// is run in the background, one second after the session that
+	// started it ends, so the session is not cut off by the
+	// reboot. If it fails, its output and exit code are kept
+	// in a private directory created by "mktemp -d", so the
+	// failure is reported while waiting for the host to go down
+	// rather than after DownTimeout. The directory is removed
+	// once it has been read. The default is DefaultRebootCommand.
+	Command string
+
+	// BootIDCommand prints an ID that changes each time the host
+	// boots, e.g., "sysctl -n kern.boottime" on BSD hosts. The
+	// default is DefaultBootIDCommand.
+	BootIDCommand string
+
+	// DownTimeout is how long to wait for the host to go down
+	// after Command is issued. The default is
+	// DefaultRebootDownTimeout.
+	DownTimeout time.Duration
+
+	// PollTimeout limits each poll of the boot ID, including
+	// connecting, so a host that hangs while going down does not
+	// stall Reboot(). The default is DefaultRebootPollTimeout.
+	PollTimeout time.Duration
+
+	// Ready configures waiting for the host to come back. Its
+	// Backoff is also used to poll for the host going down.
+	Ready WaitReadyConfig
+}
+
+// RebootResult reports the progress of Remote.Reboot().
+type RebootResult struct {
+	// OldBootID is the boot ID before the reboot.
+	OldBootID string
+
+	// NewBootID is the boot ID after the reboot.
+	NewBootID string
+
+	// Down is the time from issuing the reboot until the host was
+	// seen going down, either because it stopped accepting
+	// connections or because its boot ID changed.
+	Down time.Duration
+
+	// Ready is the result of waiting for the host to come back,
+	// or nil if it was not seen going down.
+	Ready *ReadyResult
+
+	// Duration is the total time spent rebooting.
+	Duration time.Duration
+}
+
+// Reboot reboots the host and waits until it is back. It reads the boot
+// ID, issues the reboot in the background so the command does not fail
+// or hang when the connection drops, waits for the host to go down,
+// waits for it to be ready using WaitReady(), and verifies that its
+// boot ID changed. The host is down once it stops accepting
+// connections or reports a new boot ID. Reboot() fails with
+// ErrNotRebooted if the host does not go down within
+// config.DownTimeout or comes back with the old boot ID:
+//
+//     res, err := remote.Reboot(ctx, run.RebootConfig{
+//         Command: "sudo systemctl reboot",
+//         Ready:   run.WaitReadyConfig{Command: "systemctl is-system-running"},
+//     })
+//
+// The shared connection, if open, is lost by the reboot and is
+// reconnected by the next command.
+func (r *Remote) Reboot(ctx context.Context, config RebootConfig) (*RebootResult, error) {
+	if config.Command == "" {
+		config.Command = DefaultRebootCommand
+	}
+	if config.BootIDCommand == "" {
+		config.BootIDCommand = DefaultBootIDCommand
+	}
+	if config.DownTimeout <= 0 {
+		config.DownTimeout = DefaultRebootDownTimeout
+	}
+	if config.PollTimeout <= 0 {
+		config.PollTimeout = DefaultRebootPollTimeout
+	}
+	start := time.Now()
+	res := &RebootResult{}
+	var err error
+	res.OldBootID, err = r.bootID(ctx, config)
+	if err != nil {
+		return res, err
+	}
+
+	logAttrs(r.Logger, slog.LevelInfo, "ssh rebooting",
+		append(r.logAttrs(), slog.String("boot_id", res.OldBootID))...)
+	// If the command fails, its output and exit code are kept in a
+	// private directory, whose path is printed, so waitDown() can
+	// report it.
+	script := fmt.Sprintf(`sleep 1; if (%s) >"$1/out" 2>&1; then rm -rf "$1"; else echo $? >"$1/failed"; fi`,
+		config.Command)
+	cmdLine := fmt.Sprintf(`dir=$(mktemp -d) && echo "$dir" && nohup sh -c %s sh "$dir" </dev/null >/dev/null 2>&1 &`,
+		shellQuote(script))
+	issued := time.Now()
+	stdout, stderr, code, err := r.exec(cmdLine)
+	status := strings.TrimSpace(stdout)
+	switch {
+	case errors.Is(err, ErrConnectionLost):
+		// The host went down before the session ended.
+	case err != nil:
+		return res, fmt.Errorf("run: cannot reboot %s: %w", r.Credentials.Hostname, err)
+	case code != 0:
+		return res, r.redactError(fmt.Errorf("run: cannot reboot %s: exit code %d: %s",
+			r.Credentials.Hostname, code, Excerpt(strings.TrimSpace(stderr), ErrorOutputBytes)))
+	}
+
+	if err = r.waitDown(ctx, config, res.OldBootID, status); err != nil {
+		if errors.Is(err, ErrNotRebooted) {
+			// The host is still up.
+			r.removeRebootStatus(ctx, config, status)
+		}
+		res.Duration = time.Since(start)
+		return res, err
+	}
+	res.Down = time.Since(issued)
+	logAttrs(r.Logger, slog.LevelInfo, "ssh host down",
+		append(r.logAttrs(), slog.Duration("duration", res.Down))...)
+
+	res.Ready, err = r.WaitReady(ctx, config.Ready)
+	if err != nil {
+		res.Duration = time.Since(start)
+		return res, err
+	}
+	r.removeRebootStatus(ctx, config, status)
+	res.NewBootID, err = r.bootID(ctx, config)
+	res.Duration = time.Since(start)
+	if err != nil {
+		return res, err
+	}
+	if res.NewBootID == res.OldBootID {
+		return res, fmt.Errorf("%w: %s came back with the same boot ID %s",
+			ErrNotRebooted, r.Credentials.Hostname, res.OldBootID)
+	}
+	logAttrs(r.Logger, slog.LevelInfo, "ssh rebooted",
+		append(r.logAttrs(),
+			slog.String("boot_id", res.NewBootID),
+			slog.Duration("duration", res.Duration))...)
+
+	return res, nil
+}
+
+// waitDown polls the host until it stops accepting connections or
+// reports a boot ID other than oldBootID. It fails if the reboot
+// command recorded a failure in the status directory.
+func (r *Remote) waitDown(ctx context.Context, config RebootConfig, oldBootID string, status string) error {
+	timer := time.NewTimer(config.DownTimeout)
+	defer timer.Stop()
+	for n := 1; ; n++ {
+		down, err := r.pollDown(ctx, config, oldBootID, status)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("run: %s did not go down after reboot: %w", r.Credentials.Hostname, ctxErr)
+		}
+		if err != nil || down {
+			return err
+		}
+		delay := time.NewTimer(config.Ready.Backoff.Delay(n))
+		select {
+		case <-ctx.Done():
+			delay.Stop()
+			return fmt.Errorf("run: %s did not go down after reboot: %w", r.Credentials.Hostname, ctx.Err())
+		case <-timer.C:
+			delay.Stop()
+			return fmt.Errorf("%w: %s did not go down within %s", ErrNotRebooted, r.Credentials.Hostname, config.DownTimeout)
+		case <-delay.C:
+		}
+	}
+}
+
+// pollDown polls the host once and returns true if it is down, i.e., if
+// connecting to it fails or it reports a boot ID other than oldBootID.
+// Other failures, e.g., of the boot ID command, leave the host up.
+func (r *Remote) pollDown(ctx context.Context, config RebootConfig, oldBootID string, status string) (bool, error) {
+	if r.pollTCP(ctx) != nil {
+		return true, nil
+	}
+	bootID, err := r.bootID(ctx, config)
+	switch {
+	case err == nil && bootID != oldBootID:
+		return true, nil
+	case errors.Is(err, ErrConnectFailed), errors.Is(err, ErrConnectTimeout):
+		return true, nil
+	}
+
+	return false, r.rebootFailed(ctx, config, status)
+}
+
+// bootID runs the boot ID command on a connection of its own and
+// returns its trimmed output. It gives up after config.PollTimeout or
+// when ctx is done.
+func (r *Remote) bootID(ctx context.Context, config RebootConfig) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.PollTimeout)
+	defer cancel()
+	stdout, stderr, code, err := r.execContext(ctx, true, nil, nil, nil, config.BootIDCommand)
+	bootID := strings.TrimSpace(stdout)
+	switch {
+	case err != nil:
+	case code != 0:
+		err = fmt.Errorf("exit code %d: %s", code, Excerpt(strings.TrimSpace(stderr), ErrorOutputBytes))
+	case bootID == "":
+		err = errors.New("no output")
+	}
+	if err != nil {
+		return "", r.redactError(fmt.Errorf("run: cannot read boot ID of %s: %w", r.Credentials.Hostname, err))
+	}
+
+	return bootID, nil
+}
+
+// rebootFailed returns an error if the reboot command recorded a
+// failure in the status directory, and removes the directory. It
+// returns nil if the directory is unknown or cannot be read.
+func (r *Remote) rebootFailed(ctx context.Context, config RebootConfig, status string) error {
+	if status == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.PollTimeout)
+	defer cancel()
+	dir := shellQuote(status)
+	stdout, _, code, err := r.execContext(ctx, true, nil, nil, nil,
+		fmt.Sprintf(`test -e %s/failed && cat %s/failed %s/out && rm -rf %s`, dir, dir, dir, dir))
+	if err != nil || code != 0 {
+		return nil
+	}
+	exitCode, output := strings.TrimSpace(stdout), ""
+	if i := strings.IndexByte(stdout, '\n'); i >= 0 {
+		exitCode, output = stdout[:i], strings.TrimSpace(stdout[i+1:])
+	}
+	if output == "" {
+		return fmt.Errorf("run: cannot reboot %s: exit code %s", r.Credentials.Hostname, exitCode)
+	}
+
+	return r.redactError(fmt.Errorf("run: cannot reboot %s: exit code %s: %s",
+		r.Credentials.Hostname, exitCode, Excerpt(output, ErrorOutputBytes)))
+}
+
+// removeRebootStatus removes the status directory of the reboot
+// command, if it still exists. Failures are ignored.
+func (r *Remote) removeRebootStatus(ctx context.Context, config RebootConfig, status string) {
+	if status == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.PollTimeout)
+	defer cancel()
+	r.execContext(ctx, true, nil, nil, nil, "rm -rf "+shellQuote(status)) // nolint
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRebootRemote returns a Remote for a test host whose boot ID is
+// read from a file and that refuses connections while a "down" file
+// exists, and the directory holding the files. The status directories
+// of reboot commands are created in its "tmp" subdirectory.
+func newRebootRemote(t *testing.T) (*run.Remote, string) {
+	r, _ := newPasswordRemote(t)
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "boot_id"), []byte("one\n"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "tmp"), 0700))
+	t.Cleanup(setenv(t, map[string]string{"TMPDIR": filepath.Join(dir, "tmp")}))
+	r.Dialer = run.DialerFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, err := os.Stat(filepath.Join(dir, "down")); err == nil {
+			return nil, syscall.ECONNREFUSED
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+
+	return r, dir
+}
+
+// assertNoRebootStatus asserts that the status directories of the
+// reboot commands run on the host returned by newRebootRemote() were
+// removed.
+func assertNoRebootStatus(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestRemote_Reboot(t *testing.T) {
+	backoff := run.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	// The host comes back before it is seen going down.
+	r, dir := newRebootRemote(t)
+	config := run.RebootConfig{
+		Command:       "echo two > " + dir + "/boot_id",
+		BootIDCommand: "cat " + dir + "/boot_id",
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	}
+	res, err := r.Reboot(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, "one", res.OldBootID)
+	assert.Equal(t, "two", res.NewBootID)
+	assert.True(t, res.Down >= time.Second)
+	require.NotNil(t, res.Ready)
+	assert.True(t, res.Duration >= res.Down+res.Ready.Duration)
+
+	// The host stops accepting connections for a while.
+	require.NoError(t, r.Connect())
+	config.Command = "touch " + dir + "/down; sleep 0.3; echo three > " + dir + "/boot_id; rm " + dir + "/down"
+	config.Ready.Command = "test -e " + dir + "/boot_id"
+	res, err = r.Reboot(context.Background(), config)
+	require.NoError(t, err)
+	assert.Equal(t, "two", res.OldBootID)
+	assert.Equal(t, "three", res.NewBootID)
+	require.NotNil(t, res.Ready)
+	assert.True(t, res.Ready.Stage(run.ReadyStageTCP).Attempts > 1)
+	assert.True(t, res.Ready.Stage(run.ReadyStageCommand).Done)
+	stdout, _, _, err := r.Run("cat", dir+"/boot_id")
+	require.NoError(t, err)
+	assert.Equal(t, "three\n", stdout)
+	assertNoRebootStatus(t, dir)
+}
+
+func TestRemote_RebootErrors(t *testing.T) {
+	backoff := run.Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	r, dir := newRebootRemote(t)
+
+	// The host does not go down while the command runs. Its status
+	// directory is removed anyway.
+	res, err := r.Reboot(context.Background(), run.RebootConfig{
+		Command:       "sleep 2",
+		BootIDCommand: "cat " + dir + "/boot_id",
+		DownTimeout:   1500 * time.Millisecond,
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrNotRebooted))
+	assert.Contains(t, err.Error(), "127.0.0.1 did not go down within 1.5s")
+	assert.Nil(t, res.Ready)
+	assertNoRebootStatus(t, dir)
+
+	// The host goes away and comes back with the same boot ID.
+	res, err = r.Reboot(context.Background(), run.RebootConfig{
+		Command:       "touch " + dir + "/down; sleep 0.3; rm " + dir + "/down",
+		BootIDCommand: "cat " + dir + "/boot_id",
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrNotRebooted))
+	assert.Contains(t, err.Error(), "came back with the same boot ID one")
+	assert.NotNil(t, res.Ready)
+
+	// The reboot command fails, which is reported long before
+	// DownTimeout.
+	start := time.Now()
+	res, err = r.Reboot(context.Background(), run.RebootConfig{
+		Command:       "echo not permitted >&2; exit 3",
+		BootIDCommand: "cat " + dir + "/boot_id",
+		DownTimeout:   time.Minute,
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, run.ErrNotRebooted))
+	assert.Contains(t, err.Error(), "run: cannot reboot 127.0.0.1: exit code 3: not permitted")
+	assert.True(t, time.Since(start) < 10*time.Second)
+	assert.Nil(t, res.Ready)
+	assertNoRebootStatus(t, dir)
+
+	// Failures of the boot ID command do not mean the host is
+	// down.
+	res, err = r.Reboot(context.Background(), run.RebootConfig{
+		Command:       "touch " + dir + "/broken",
+		BootIDCommand: "test ! -e " + dir + "/broken && cat " + dir + "/boot_id",
+		DownTimeout:   1500 * time.Millisecond,
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrNotRebooted))
+	assert.Nil(t, res.Ready)
+
+	// A boot ID command that hangs is given up after PollTimeout.
+	start = time.Now()
+	_, err = r.Reboot(context.Background(), run.RebootConfig{
+		Command:       "touch " + dir + "/hang",
+		BootIDCommand: "test -e " + dir + "/hang && sleep 10; cat " + dir + "/boot_id",
+		DownTimeout:   1500 * time.Millisecond,
+		PollTimeout:   200 * time.Millisecond,
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrNotRebooted))
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// The boot ID cannot be read.
+	_, err = r.Reboot(context.Background(), run.RebootConfig{
+		BootIDCommand: "cat " + dir + "/missing",
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "run: cannot read boot ID of 127.0.0.1")
+
+	// The context ends while the host is down.
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	_, err = r.Reboot(ctx, run.RebootConfig{
+		Command:       "touch " + dir + "/down",
+		BootIDCommand: "cat " + dir + "/boot_id",
+		Ready:         run.WaitReadyConfig{Backoff: backoff},
+	})
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "at stage tcp")
+}
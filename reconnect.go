@@ -0,0 +1,104 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// DefaultReconnectAttempts is the number of attempts made to replace a
+// lost shared connection if RemoteConfig.ReconnectAttempts is not set.
+const DefaultReconnectAttempts = 3
+
+// notStartedError marks an error that occurred before the command was
+// sent to the host.
+type notStartedError struct {
+	err error
+}
+
+func (e *notStartedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notStartedError) Unwrap() error {
+	return e.err
+}
+
+// notStarted marks err as occurring before the command was sent to the
+// host.
+func notStarted(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &notStartedError{err: err}
+}
+
+// IsNotStarted returns true if err, returned by Remote.Run(),
+// Remote.Shell(), or Remote.Subsystem(), shows the command was never
+// sent to the host, e.g., because the connection or authentication
+// failed or the host refused the command. Such commands can be retried
+// safely. It returns false if the command might have run, in part or
+// completely, e.g., because the connection was lost while it ran; only
+// retry those if the command is idempotent.
+func IsNotStarted(err error) bool {
+	var ns *notStartedError
+
+	return errors.As(err, &ns)
+}
+
+// RetryOnNotStarted returns a RetryPredicate that retries attempts that
+// failed before the command was sent to the host. See IsNotStarted().
+func RetryOnNotStarted() RetryPredicate {
+	return RetryOnError(IsNotStarted)
+}
+
+// alive returns true if the connection has not ended.
+func (c *sshConn) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// reconnect replaces the lost shared connection. It makes up to
+// ReconnectAttempts attempts separated by ReconnectBackoff delays and
+// stops early once cancel is closed or ctx is done. Authentication
+// failures are not retried.
+func (r *Remote) reconnect(ctx context.Context, cancel <-chan struct{}) (*sshConn, error) {
+	logAttrs(r.Logger, slog.LevelWarn, "ssh reconnecting", r.logAttrs()...)
+	var err error
+	for n := 1; n <= r.ReconnectAttempts; n++ {
+		if n > 1 {
+			timer := time.NewTimer(r.ReconnectBackoff.Delay(n - 1))
+			select {
+			case <-cancel:
+				timer.Stop()
+				return nil, r.connError("dial", ErrConnectionLost, errors.New("closed while reconnecting"))
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, r.connError("dial", ErrConnectionLost, ctx.Err())
+			case <-timer.C:
+			}
+		}
+		var client *sshConn
+		client, err = r.open(ctx)
+		if err == nil {
+			logAttrs(r.Logger, slog.LevelInfo, "ssh reconnected",
+				append(r.logAttrs(), slog.Int("attempts", n))...)
+			return client, nil
+		}
+		if errors.Is(err, ErrAuthFailed) {
+			break
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemote_Reconnect(t *testing.T) {
+	r, server := newPasswordRemote(t)
+	r.ReconnectBackoff = run.Backoff{Initial: 10 * time.Millisecond}
+	require.NoError(t, r.Connect())
+	_, _, _, err := r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.Connections())
+
+	// The next command reconnects.
+	server.DropConnections()
+	stdout, _, code, err := r.Run("echo", "back")
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "back\n", stdout)
+	assert.Equal(t, 2, server.Connections())
+
+	// A command running when the connection is lost might have
+	// run.
+	time.AfterFunc(200*time.Millisecond, server.DropConnections)
+	_, _, _, err = r.Run("sleep", "5")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectionLost))
+	assert.False(t, run.IsNotStarted(err))
+	assert.False(t, run.RetryOnNotStarted()(run.Attempt{Err: err}))
+	_, _, _, err = r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, 3, server.Connections())
+
+	// A host that does not come back fails the next command
+	// before it is sent.
+	server.DropConnections()
+	server.Close()
+	start := time.Now()
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, run.ErrConnectFailed))
+	assert.True(t, run.IsNotStarted(err))
+	assert.True(t, run.RetryOnNotStarted()(run.Attempt{Err: err}))
+	// Three attempts separated by 10ms and 20ms.
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestRemote_ReconnectDisabled(t *testing.T) {
+	r, server := newPasswordRemote(t)
+	r.ReconnectAttempts = -1
+	require.NoError(t, r.Connect())
+	server.DropConnections()
+	for i := 0; i < 2; i++ {
+		_, _, _, err := r.Run("true")
+		t.Logf("err = %v", err)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, run.ErrConnectionLost))
+		assert.True(t, run.IsNotStarted(err))
+	}
+	assert.Equal(t, 1, server.Connections())
+
+	// Close() ends the shared connection, so commands use
+	// connections of their own again.
+	require.NoError(t, r.Close())
+	_, _, _, err := r.Run("true")
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.Connections())
+}
+
+func TestRemote_ReconnectClose(t *testing.T) {
+	r, server := newPasswordRemote(t)
+	r.ReconnectBackoff = run.Backoff{Initial: time.Minute}
+	require.NoError(t, r.Connect())
+	server.DropConnections()
+	server.Close()
+
+	// Close() is not blocked by a reconnection waiting between
+	// attempts and stops it.
+	done := make(chan error)
+	go func() {
+		_, _, _, err := r.Run("true")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, r.Close())
+	select {
+	case err := <-done:
+		t.Logf("err = %v", err)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, run.ErrConnectionLost))
+		assert.True(t, run.IsNotStarted(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reconnection was not stopped by Close()")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRemote_CloseWhileRunning(t *testing.T) {
+	r, server := newPasswordRemote(t)
+
+	// Commands racing with Close() run on the shared connection or
+	// fail, but never reopen it, so commands run after Close() use
+	// connections of their own.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, r.Connect())
+		var wg sync.WaitGroup
+		closed := make(chan struct{})
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-closed:
+						return
+					default:
+					}
+					r.Run("true") // nolint
+				}
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, r.Close())
+		close(closed)
+		wg.Wait()
+		n := server.Connections()
+		_, _, _, err := r.Run("true")
+		require.NoError(t, err)
+		require.Equal(t, n+1, server.Connections(), "the shared connection was reopened")
+	}
+}
+
+func TestIsNotStarted(t *testing.T) {
+	assert.False(t, run.IsNotStarted(nil))
+	assert.False(t, run.IsNotStarted(errors.New("some error")))
+
+	// Authentication failures are not retried by the reconnection
+	// and the command is not sent.
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	creds := server.Credentials("deploy")
+	creds.Password = "wrong"
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	_, _, _, err = r.Run("true")
+	t.Logf("err = %v", err)
+	assert.True(t, errors.Is(err, run.ErrAuthFailed))
+	assert.True(t, run.IsNotStarted(err))
+}
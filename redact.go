@@ -0,0 +1,190 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedText replaces secrets in redacted strings.
+const RedactedText = "******"
+
+// DefaultRedactor is the Redactor used by Local and Remote if one is
+// not given in their configuration. Secrets registered with it are
+// hidden by every runner that uses it, including the standard runner.
+var DefaultRedactor = NewRedactor()
+
+// Redactor is a registry of secrets that are hidden whenever a runner
+// turns a command, its output, or an error into a string, e.g., in
+// FormatRun(), FormatShell(), log events, and errors. Secrets can be
+// registered as literal values, regular expressions, or flag name
+// patterns. The captured standard out and standard error returned by
+// Run() and Shell() are never modified.
+//
+// A Redactor is safe for concurrent use.
+type Redactor struct {
+	mu       sync.RWMutex
+	literals []string
+	regexps  []*regexp.Regexp
+	flags    []*regexp.Regexp
+}
+
+// NewRedactor is the constructor for Redactor. The returned Redactor
+// has no secrets registered.
+func NewRedactor() *Redactor {
+	return new(Redactor)
+}
+
+// AddLiteral registers secrets that are hidden wherever they appear.
+// Empty strings and secrets that are already registered are ignored.
+func (r *Redactor) AddLiteral(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range secrets {
+		if s != "" && !r.hasLiteral(s) {
+			r.literals = append(r.literals, s)
+		}
+	}
+	// Replace longer secrets first so a secret containing another
+	// one is hidden completely.
+	sort.SliceStable(r.literals, func(i, j int) bool {
+		return len(r.literals[i]) > len(r.literals[j])
+	})
+}
+
+// hasLiteral returns true if s is registered. r.mu must be held.
+func (r *Redactor) hasLiteral(s string) bool {
+	for _, lit := range r.literals {
+		if lit == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddRegexp registers regular expressions matching secrets. If a
+// regular expression has capturing groups only the text matched by
+// the groups is hidden, otherwise the whole match is hidden. For
+// example, `Authorization: Bearer (\S+)` hides the token but leaves
+// the header name visible.
+func (r *Redactor) AddRegexp(res ...*regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.regexps = append(r.regexps, res...)
+}
+
+// AddFlag registers command-line flags whose values are secrets. A
+// pattern is a flag name optionally followed by "=*", e.g.,
+// "--password=*", and may use "*" as a wildcard in the name, e.g.,
+// "--*-token=*". The value is hidden whether it is given in the
+// "--flag=value" or the "--flag value" form.
+func (r *Redactor) AddFlag(patterns ...string) error {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		name := strings.TrimSuffix(pattern, "=*")
+		if name == "" || strings.ContainsAny(name, " \t=") {
+			return fmt.Errorf("run: invalid flag redaction pattern %q", pattern)
+		}
+		parts := strings.Split(name, "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		res = append(res, regexp.MustCompile(
+			`(?:^|[\s"'])`+strings.Join(parts, `[^\s="']*`)+
+				`(?:=|\s+)("[^"]*"|'[^']*'|[^\s"']+)`))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flags = append(r.flags, res...)
+
+	return nil
+}
+
+// Redact returns s with all registered secrets hidden. A nil
+// Redactor returns s unchanged.
+func (r *Redactor) Redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, re := range r.flags {
+		s = redactGroups(re, s)
+	}
+	for _, re := range r.regexps {
+		s = redactGroups(re, s)
+	}
+	for _, lit := range r.literals {
+		s = strings.Replace(s, lit, RedactedText, -1)
+	}
+
+	return s
+}
+
+// RedactError returns err with all registered secrets hidden in its
+// message. The returned error wraps err, so errors.Is() and
+// errors.As() work as they would on err. It returns err itself if
+// nothing needed to be hidden.
+func (r *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := r.Redact(msg)
+	if redacted == msg {
+		return err
+	}
+
+	return &redactedError{msg: redacted, err: err}
+}
+
+// redactGroups replaces the text matched by re's capturing groups, or
+// by all of re if it has none, with RedactedText.
+func redactGroups(re *regexp.Regexp, s string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		spans := m[2:]
+		if len(spans) == 0 {
+			spans = m[:2]
+		}
+		for i := 0; i < len(spans); i += 2 {
+			start, end := spans[i], spans[i+1]
+			if start < last {
+				// Unmatched or nested group.
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(RedactedText)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// redactedError is an error whose message has had secrets hidden.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactor_Literal(t *testing.T) {
+	r := run.NewRedactor()
+	r.AddLiteral("s3cret", "", "s3cret-and-more")
+
+	msg := r.Redact("mysql -p s3cret-and-more; echo s3cret")
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, "mysql -p ******; echo ******", msg)
+
+	// Registering a secret again changes nothing.
+	r.AddLiteral("s3cret")
+	assert.Equal(t, "mysql -p ******; echo ******", r.Redact("mysql -p s3cret-and-more; echo s3cret"))
+}
+
+func TestRedactor_Regexp(t *testing.T) {
+	r := run.NewRedactor()
+	r.AddRegexp(
+		regexp.MustCompile(`Authorization: Bearer ([^\s"]+)`),
+		regexp.MustCompile(`ghp_[A-Za-z0-9]+`))
+
+	msg := r.Redact(`curl -H "Authorization: Bearer abc.def" https://x/?t=ghp_123abc`)
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, `curl -H "Authorization: Bearer ******" https://x/?t=******`, msg)
+}
+
+func TestRedactor_Flag(t *testing.T) {
+	r := run.NewRedactor()
+	require.NoError(t, r.AddFlag("--password=*", "--*-token=*", "-p"))
+	assert.Error(t, r.AddFlag("=*"))
+
+	for _, tc := range []struct {
+		in, out string
+	}{
+		{"mysql --password=s3cret db", "mysql --password=****** db"},
+		{"mysql --password s3cret db", "mysql --password ****** db"},
+		{`/bin/sh -c "mysql --password='a b' db"`, `/bin/sh -c "mysql --password=****** db"`},
+		{`/bin/sh -c "mysql --password=s3cret"`, `/bin/sh -c "mysql --password=******"`},
+		{"deploy --api-token=abc --verbose", "deploy --api-token=****** --verbose"},
+		{"mysql -p s3cret", "mysql -p ******"},
+		{"mysql --password-file=/etc/pw", "mysql --password-file=/etc/pw"},
+		{"grep -pattern x", "grep -pattern x"},
+	} {
+		msg := r.Redact(tc.in)
+		t.Logf("msg = %q", msg)
+		assert.Equal(t, tc.out, msg)
+	}
+}
+
+func TestRedactor_Error(t *testing.T) {
+	r := run.NewRedactor()
+	r.AddLiteral("s3cret")
+
+	err := r.RedactError(fmt.Errorf("login as s3cret failed: %w", os.ErrPermission))
+	t.Logf("err = %q", err)
+	assert.EqualError(t, err, "login as ****** failed: permission denied")
+	assert.True(t, errors.Is(err, os.ErrPermission))
+
+	plain := errors.New("nothing to hide")
+	assert.Equal(t, plain, r.RedactError(plain))
+	assert.NoError(t, r.RedactError(nil))
+}
+
+func TestRedactor_Nil(t *testing.T) {
+	var r *run.Redactor
+	assert.Equal(t, "echo s3cret", r.Redact("echo s3cret"))
+}
@@ -5,9 +5,12 @@
 package run
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net"
 	"os"
 	"os/user"
@@ -15,19 +18,46 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 )
 
 const (
-	defaultSSHPort        = 22
-	defaultSSHHostname    = "localhost"
-	defaultSSHKeyfileName = "id_rsa"
+	defaultSSHPort     = 22
+	defaultSSHHostname = "localhost"
 )
 
-// Credentials contains needed credentials to SSH to a host. It can
-// use either a password or SSH private key.
+// Defaults for the connection timeouts and keepalives of a Remote.
+const (
+	DefaultConnectTimeout     = 30 * time.Second
+	DefaultHandshakeTimeout   = 30 * time.Second
+	DefaultKeepaliveMaxMissed = 3
+)
+
+// lostConnectionWait is the time a failed command waits for its
+// connection to end before reporting it as lost.
+const lostConnectionWait = 250 * time.Millisecond
+
+// MinRedactedPasswordLength is the length Credentials.Password must
+// have to be registered with the Redactor of a Remote. Shorter
+// passwords are not hidden and a warning is logged.
+const MinRedactedPasswordLength = 6
+
+// defaultSSHKeyfileNames are the identity files in $HOME/.ssh searched
+// by NewRemote() in order.
+var defaultSSHKeyfileNames = []string{
+	"id_ed25519",
+	"id_ecdsa",
+	"id_rsa",
+	"id_dsa",
+}
+
+// Credentials contains needed credentials to SSH to a host. The
+// agent, private keys, password, and keyboard-interactive
+// authentication are tried in the order given by AuthMethods.
 type Credentials struct {
 	// Hostname is either the hostname or IP of the remote host.
 	Hostname string
@@ -44,21 +74,72 @@ type Credentials struct {
 	// host. Not needed if using PrivateKeyFilename.
 	Password string
 
+	// PasswordSource, if not nil, provides the password when it is
+	// needed to authenticate instead of storing it in Password. The
+	// password is read each time a connection is made and the
+	// returned bytes are zeroed after use. It is not used if
+	// Password is set.
+	PasswordSource SecretSource
+
 	// PrivateKeyFilename is the full path the SSH private key
-	// used to authenticate with the remote host.  Not used if
-	// Password is specified. You must use ssh-agent or something
-	// similar to provide the passphrase if the key is passphrase
-	// protected.
+	// used to authenticate with the remote host. If the key is
+	// passphrase protected, PassphraseCallback must be set or the
+	// key must be loaded into ssh-agent.
 	PrivateKeyFilename string
+
+	// PrivateKeyFilenames are additional private keys tried in
+	// order after PrivateKeyFilename.
+	PrivateKeyFilenames []string
+
+	// CertificateFilename is the SSH user certificate presented
+	// with PrivateKeyFilename. If it is empty, the key file name
+	// with "-cert.pub" appended is used if it exists, e.g.,
+	// "id_ed25519-cert.pub". Certificates for PrivateKeyFilenames
+	// and for keys held by ssh-agent are found the same way.
+	// Certificates are tried before the plain key. A certificate
+	// that has expired or is not valid for Username is an error if
+	// it is given by CertificateFilename and is skipped otherwise.
+	CertificateFilename string
+
+	// PassphraseCallback, if not nil, provides the passphrase of
+	// encrypted private keys in the OpenSSH or PEM format. If the
+	// public key can be read from the key file, its certificate,
+	// or the key file name with ".pub" appended, it is only called
+	// if the server accepts the key.
+	PassphraseCallback PassphraseFunc
+
+	// KeyboardInteractive, if not nil, answers keyboard-interactive
+	// challenges. The password, if any, is used to answer them
+	// otherwise.
+	KeyboardInteractive ChallengeFunc
+
+	// AuthMethods is the order authentication methods are tried
+	// in. Methods without credentials are skipped.
+	// DefaultAuthMethods is used if it is empty.
+	AuthMethods []AuthMethod
 }
 
-func defaultPrivateKeyFilename(username string) (string, error) {
-	user, err := user.Lookup(username)
+// defaultPrivateKeyFilenames returns the identity files that exist.
+// Like OpenSSH, they are searched for in the home directory of the
+// local user whatever the remote user name is.
+func defaultPrivateKeyFilenames() ([]string, error) {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return "", err
+		user, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		home = user.HomeDir
+	}
+	var filenames []string
+	for _, name := range defaultSSHKeyfileNames {
+		filename := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(filename); err == nil {
+			filenames = append(filenames, filename)
+		}
 	}
 
-	return filepath.Join(user.HomeDir, ".ssh", defaultSSHKeyfileName), nil
+	return filenames, nil
 }
 
 // RemoteConfig contains configuration data used in the Remote
@@ -83,6 +164,59 @@ type RemoteConfig struct {
 
 	// Credentials used to authenticate on the remote system.
 	Credentials Credentials
+
+	// Logger receives structured log events. See Remote for
+	// details.
+	Logger *slog.Logger
+
+	// LogOutputBytes is the size of the output excerpts included
+	// in log events. See Remote for details.
+	LogOutputBytes int
+
+	// Redactor hides secrets in formatted commands, log events,
+	// and errors. See Remote for details.
+	Redactor *Redactor
+
+	// AgentForwarding forwards the local ssh-agent to commands.
+	// See Remote for details.
+	AgentForwarding bool
+
+	// ConnectTimeout limits the time to establish the TCP
+	// connection. See Remote for details.
+	ConnectTimeout time.Duration
+
+	// HandshakeTimeout limits the time of the SSH handshake. See
+	// Remote for details.
+	HandshakeTimeout time.Duration
+
+	// KeepaliveInterval is the time between keepalives. See
+	// Remote for details.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveMaxMissed is the number of keepalive intervals
+	// without a reply after which the connection is closed. See
+	// Remote for details.
+	KeepaliveMaxMissed int
+
+	// Dialer opens the connection to the host. See Remote for
+	// details.
+	Dialer Dialer
+
+	// Algorithms lists the SSH algorithms offered to the host.
+	// See Remote for details.
+	Algorithms Algorithms
+
+	// BannerCallback receives the banner sent by the host. See
+	// Remote for details.
+	BannerCallback BannerFunc
+
+	// ReconnectAttempts is the number of attempts made to replace
+	// a lost shared connection. See Remote for details.
+	ReconnectAttempts int
+
+	// ReconnectBackoff is the delay between reconnection
+	// attempts. See Remote for details.
+	ReconnectBackoff Backoff
 }
 
 // Remote wraps ssh.Client to make running commands over SSH on a
@@ -128,7 +262,122 @@ type Remote struct {
 	// Credentials are used to authenticate with the remote host.
 	Credentials Credentials
 
-	sshSession *ssh.Session
+	// Logger, if not nil, receives a structured log event when
+	// a connection is established ("ssh connection established"
+	// at info level, with the authentication method that
+	// succeeded), with the authentication methods being tried
+	// ("ssh auth methods" at debug level), when each command is
+	// started ("command start" at debug level), when it exits
+	// ("command exit" at info level, with the duration, exit
+	// code, and output sizes), and on errors ("ssh connection
+	// failed" and "command error" at error level). Every event
+	// includes the remote host and user name.
+	Logger *slog.Logger
+
+	// LogOutputBytes is the maximum number of bytes of standard
+	// out and standard error included in "command exit" events.
+	// Output is not logged if LogOutputBytes is zero.
+	LogOutputBytes int
+
+	// Redactor hides secrets in the strings returned by
+	// FormatRun() and FormatShell(), in log events, and in
+	// returned errors. NewRemote registers Credentials.Password
+	// with Redactor unless it is shorter than
+	// MinRedactedPasswordLength, since hiding a password like
+	// "root" would mangle every message. Passwords
+	// read from Credentials.PasswordSource, passphrases returned
+	// by Credentials.PassphraseCallback, and answers to
+	// keyboard-interactive questions that are not echoed are
+	// hidden in the errors of the connection they are used for
+	// but are not registered with Redactor.
+	Redactor *Redactor
+
+	// AgentForwarding, if true, forwards the ssh-agent listening
+	// on $SSH_AUTH_SOCK to commands, like "ssh -A", so they can
+	// use its keys, e.g., "git clone" from a private repository.
+	// It is an error if $SSH_AUTH_SOCK is not set or the server
+	// refuses to forward the agent. Only forward the agent to
+	// hosts you trust; their administrators can use its keys while
+	// the command runs.
+	AgentForwarding bool
+
+	// ConnectTimeout limits the time to establish the TCP
+	// connection to the host. A negative value means no limit.
+	ConnectTimeout time.Duration
+
+	// HandshakeTimeout limits the time of the SSH handshake,
+	// including authentication, once the TCP connection is
+	// established. Increase it if PassphraseCallback or
+	// KeyboardInteractive prompt a user. A negative value means
+	// no limit.
+	HandshakeTimeout time.Duration
+
+	// KeepaliveInterval, if positive, is the time between the
+	// keepalive@openssh.com requests sent on every connection. A
+	// connection is closed as lost if KeepaliveMaxMissed
+	// intervals pass without a reply, so commands and tunnels on
+	// a dead host fail instead of hanging.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveMaxMissed is the number of keepalive intervals
+	// without a reply after which the connection is closed.
+	KeepaliveMaxMissed int
+
+	// Dialer opens the TCP connection to the host, which carries
+	// the SSH connection. Use a SOCKS5Dialer or HTTPConnectDialer
+	// to go through a proxy, another Remote to go through a jump
+	// host, or a DialerFunc to use a connection opened by other
+	// means. ConnectTimeout is applied to the context passed to
+	// Dialer.DialContext().
+	Dialer Dialer
+
+	// Algorithms lists the key exchange, cipher, MAC, and host
+	// key algorithms offered to the host. Use the
+	// AlgorithmsModern preset to enforce a strict baseline or
+	// AlgorithmsCompat to reach legacy appliances. NewRemote()
+	// fills the empty lists from the preset and fails if a list
+	// names an unsupported algorithm.
+	Algorithms Algorithms
+
+	// BannerCallback, if not nil, receives the banner the host
+	// sends before authentication. The banner is also returned
+	// by Banner().
+	BannerCallback BannerFunc
+
+	// ReconnectAttempts is the number of attempts made to replace
+	// the shared connection when the next command, tunnel
+	// connection, or dial finds it lost, e.g., because the host
+	// rebooted. Authentication failures are not retried. Zero
+	// or a negative value disables reconnection, so the calls fail
+	// with ErrConnectionLost until Close() is called. Tunnels
+	// created by ForwardRemote() stop when the connection is
+	// lost. Use IsNotStarted() to find whether a failed command
+	// can be retried safely.
+	ReconnectAttempts int
+
+	// ReconnectBackoff is the delay between reconnection
+	// attempts.
+	ReconnectBackoff Backoff
+
+	defaultKey bool
+
+	// authMu guards the details of the last connection.
+	authMu        sync.Mutex
+	lastAuth      AuthInfo
+	serverVersion string
+	banner        string
+
+	connMu         sync.Mutex
+	shared         bool
+	client         *sshConn
+	agentForwarded bool
+	tunnels        map[*Tunnel]struct{}
+
+	// connecting is closed when the shared connection being
+	// opened without connMu held is ready or has failed.
+	// Closing connCancel stops reconnection attempts.
+	connecting chan struct{}
+	connCancel chan struct{}
 }
 
 // NewRemote is the constructor for Remote. It takes a RemoteConfig
@@ -139,12 +388,33 @@ type Remote struct {
 //     Stdin = nil  // Discard stdin.
 //     Stdout = nil // Capture stdout.
 //     Stderr = nil // Capture stderr,
+//     Logger = nil // No logging.
+//     LogOutputBytes = 0 // Do not log output.
+//     Redactor = DefaultRedactor
+//     AgentForwarding = false
+//     ConnectTimeout = DefaultConnectTimeout
+//     HandshakeTimeout = DefaultHandshakeTimeout
+//     KeepaliveInterval = 0 // No keepalives.
+//     KeepaliveMaxMissed = DefaultKeepaliveMaxMissed
+//     Dialer = &net.Dialer{}
+//     Algorithms = Algorithms{} // The golang.org/x/crypto/ssh defaults.
+//     BannerCallback = nil
+//     ReconnectAttempts = DefaultReconnectAttempts
+//     ReconnectBackoff = Backoff{} // The Backoff defaults.
 //     Credentials.Hostname = "localhost"
 //     Credentials.Port = 22
 //     Credentials.Username = Current user
 //     Credentials.Password = ""
-//     Credentials.PrivateKeyFilename = Current users default private RSA
-//     keyfile ($HOME/.ssh/id_rsa) if present.
+//     Credentials.PasswordSource = nil
+//     Credentials.PrivateKeyFilename = The first of the local users
+//     identity files ($HOME/.ssh/id_ed25519, id_ecdsa, id_rsa, and
+//     id_dsa, in that order) that exists.
+//     Credentials.PrivateKeyFilenames = The other identity files that
+//     exist.
+//     Credentials.CertificateFilename = ""
+//     Credentials.PassphraseCallback = nil
+//     Credentials.KeyboardInteractive = nil
+//     Credentials.AuthMethods = DefaultAuthMethods
 func NewRemote(config RemoteConfig) (*Remote, error) {
 	r := new(Remote)
 	if len(config.ShellExecutable) == 0 {
@@ -156,6 +426,41 @@ func NewRemote(config RemoteConfig) (*Remote, error) {
 	r.Stdout = config.Stdout
 	r.Stderr = config.Stderr
 	r.Credentials = config.Credentials
+	r.Logger = config.Logger
+	r.LogOutputBytes = config.LogOutputBytes
+	r.Redactor = config.Redactor
+	r.AgentForwarding = config.AgentForwarding
+	r.ConnectTimeout = config.ConnectTimeout
+	r.HandshakeTimeout = config.HandshakeTimeout
+	r.KeepaliveInterval = config.KeepaliveInterval
+	r.KeepaliveMaxMissed = config.KeepaliveMaxMissed
+	r.Dialer = config.Dialer
+	if r.Dialer == nil {
+		r.Dialer = &net.Dialer{}
+	}
+	algorithms, err := config.Algorithms.resolve()
+	if err != nil {
+		return nil, err
+	}
+	r.Algorithms = algorithms
+	r.BannerCallback = config.BannerCallback
+	r.ReconnectAttempts = config.ReconnectAttempts
+	r.ReconnectBackoff = config.ReconnectBackoff
+	if r.ReconnectAttempts == 0 {
+		r.ReconnectAttempts = DefaultReconnectAttempts
+	}
+	if r.ConnectTimeout == 0 {
+		r.ConnectTimeout = DefaultConnectTimeout
+	}
+	if r.HandshakeTimeout == 0 {
+		r.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if r.KeepaliveMaxMissed <= 0 {
+		r.KeepaliveMaxMissed = DefaultKeepaliveMaxMissed
+	}
+	if r.Redactor == nil {
+		r.Redactor = DefaultRedactor
+	}
 	if r.Credentials.Hostname == "" {
 		r.Credentials.Hostname = defaultSSHHostname
 	}
@@ -169,129 +474,494 @@ func NewRemote(config RemoteConfig) (*Remote, error) {
 		}
 		r.Credentials.Username = user.Username
 	}
-	if r.Credentials.Password == "" && r.Credentials.PrivateKeyFilename == "" {
-		keyFilename, err := defaultPrivateKeyFilename(r.Credentials.Username)
+	if password := r.Credentials.Password; len(password) >= MinRedactedPasswordLength {
+		r.Redactor.AddLiteral(password)
+	} else if password != "" {
+		logAttrs(r.Logger, slog.LevelWarn, "ssh password too short to redact",
+			append(r.logAttrs(), slog.Int("min_length", MinRedactedPasswordLength))...)
+	}
+	if !r.hasPassword() && r.Credentials.PrivateKeyFilename == "" && len(r.Credentials.PrivateKeyFilenames) == 0 {
+		keyFilenames, err := defaultPrivateKeyFilenames()
 		if err != nil {
 			return nil, err
 		}
-		r.Credentials.PrivateKeyFilename = keyFilename
+		if len(keyFilenames) > 0 {
+			r.Credentials.PrivateKeyFilename = keyFilenames[0]
+			r.Credentials.PrivateKeyFilenames = keyFilenames[1:]
+		}
+		r.defaultKey = true
 	}
 
 	return r, nil
 }
 
-func (r *Remote) getSSHAuths() ([]ssh.AuthMethod, error) {
-	var auths []ssh.AuthMethod
-	if r.Credentials.Password != "" {
-		auths = []ssh.AuthMethod{ssh.Password(r.Credentials.Password)}
-	} else {
-		sshAuthSockEnv := os.Getenv("SSH_AUTH_SOCK")
-		if sshAuthSockEnv != "" {
-			sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
-			if err != nil {
-				return nil, err
-			}
-			agent := agent.NewClient(sock)
-			signers, err := agent.Signers()
-			if err != nil {
-				return nil, err
-			}
-			auths = []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+// redact hides secrets in s.
+func (r *Remote) redact(s string) string {
+	return r.Redactor.Redact(s)
+}
 
-			return auths, nil
-		}
-		keyBuf, err := ioutil.ReadFile(r.Credentials.PrivateKeyFilename)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"run: could not read private key file '%s': %s",
-				r.Credentials.PrivateKeyFilename,
-				err)
-		}
-		key, err := ssh.ParsePrivateKey(keyBuf)
-		if err != nil {
-			return nil, fmt.Errorf(
-				"run: could not use private key file '%s': %s",
-				r.Credentials.PrivateKeyFilename,
-				err)
-		}
-		auths = []ssh.AuthMethod{ssh.PublicKeys(key)}
+// redactError hides secrets in the message of err.
+func (r *Remote) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := r.redact(msg)
+	if redacted == msg {
+		return err
 	}
 
-	return auths, nil
+	return &redactedError{msg: redacted, err: err}
 }
 
-func (r *Remote) open() error {
-	auths, err := r.getSSHAuths()
+// logAttrs returns the attributes added to every log event.
+func (r *Remote) logAttrs() []slog.Attr {
+	return []slog.Attr{
+		slog.String("host", r.Credentials.Hostname),
+		slog.String("user", r.Credentials.Username),
+	}
+}
+
+// open connects to the host. Errors are redacted and logged.
+func (r *Remote) open(ctx context.Context) (*sshConn, error) {
+	client, err := r.connect(ctx)
+	err = r.redactError(err)
 	if err != nil {
-		return err
+		logAttrs(r.Logger, slog.LevelError, "ssh connection failed",
+			append(r.logAttrs(), slog.String("err", err.Error()))...)
 	}
+
+	return client, err
+}
+
+// connect connects to the host, giving up when ctx is done. Failures to
+// connect are reported as a ConnectionError.
+func (r *Remote) connect(ctx context.Context) (_ *sshConn, err error) {
+	start := time.Now()
+	auth, auths, err := r.getSSHAuths()
+	if err != nil {
+		return nil, err
+	}
+	defer auth.close()
+	defer func() {
+		err = auth.redactError(err)
+	}()
 	config := &ssh.ClientConfig{
 		User:            r.Credentials.Username,
 		Auth:            auths,
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint: gosec
 	}
-	client, err := ssh.Dial("tcp",
-		fmt.Sprintf("%s:%d", r.Credentials.Hostname, r.Credentials.Port),
-		config)
+	r.Algorithms.apply(config)
+	var banner string
+	config.BannerCallback = func(message string) error {
+		banner = message
+		logAttrs(r.Logger, slog.LevelDebug, "ssh banner",
+			append(r.logAttrs(), slog.String("banner", message))...)
+		if r.BannerCallback != nil {
+			return r.BannerCallback(message)
+		}
+		return nil
+	}
+	addr := net.JoinHostPort(r.Credentials.Hostname, strconv.Itoa(r.Credentials.Port))
+	dialCtx := ctx
+	if r.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, r.ConnectTimeout)
+		defer cancel()
+	}
+	conn, err := r.Dialer.DialContext(dialCtx, "tcp", addr)
 	if err != nil {
-		return fmt.Errorf("run: connection to %s@%s failed: %s",
-			r.Credentials.Username,
-			r.Credentials.Hostname,
-			err)
+		reason := ErrConnectFailed
+		if isTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+			reason = ErrConnectTimeout
+		}
+		return nil, r.connError("dial", reason, err)
+	}
+	// Closing the connection, rather than setting a deadline, also
+	// works for connections without deadlines, e.g., those dialed
+	// through a jump host.
+	var timer *time.Timer
+	if r.HandshakeTimeout > 0 {
+		timer = time.AfterFunc(r.HandshakeTimeout, func() {
+			conn.Close() // nolint
+		})
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close() // nolint
+	})
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	timedOut := timer != nil && !timer.Stop()
+	canceled := !stop()
+	if timedOut || canceled {
+		if err == nil {
+			c.Close() // nolint
+		}
+		if timedOut {
+			err = fmt.Errorf("ssh: handshake timed out after %s", r.HandshakeTimeout)
+		} else {
+			err = ctx.Err()
+		}
 	}
-	r.sshSession, err = client.NewSession()
 	if err != nil {
-		return err
+		conn.Close() // nolint
+		reason := ErrConnectFailed
+		switch {
+		case timedOut, errors.Is(err, context.DeadlineExceeded):
+			reason = ErrConnectTimeout
+		case auth.started:
+			reason = ErrAuthFailed
+		}
+		if auth.certErr != nil {
+			err = fmt.Errorf("%s (%w)", err, auth.certErr)
+		}
+		return nil, r.connError("handshake", reason, err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	logAttrs(r.Logger, slog.LevelInfo, "ssh connection established",
+		append(r.logAttrs(),
+			slog.Int("port", r.Credentials.Port),
+			slog.String("server_version", string(client.ServerVersion())),
+			slog.String("auth_method", string(auth.attempted.Method)),
+			slog.String("auth_source", auth.attempted.Source),
+			slog.String("auth_key_id", auth.attempted.KeyID),
+			slog.Duration("duration", time.Since(start)))...)
+	r.authMu.Lock()
+	r.lastAuth = auth.attempted
+	r.serverVersion = string(client.ServerVersion())
+	r.banner = banner
+	r.authMu.Unlock()
+
+	return r.newSSHConn(client), nil
+}
+
+// Connect opens the shared connection to the host. Commands, tunnels,
+// and dialed connections all use it until Close() is called. Without
+// it, each command uses a connection of its own. ForwardLocal(),
+// ForwardRemote(), DialContext(), and ServeSOCKS() call Connect()
+// themselves. Calling Connect() when the shared connection is open
+// does nothing.
+func (r *Remote) Connect() error {
+	_, err := r.sharedClient(context.Background(), true)
+
+	return err
+}
+
+// sharedClient returns the shared connection, opening it with ctx if
+// needed. A lost connection is replaced if ReconnectAttempts is
+// positive. Only one caller opens the connection; the others wait for
+// it without holding connMu, so Close() and tunnels are not blocked
+// meanwhile. If connect is false the shared connection must still be
+// open, so a caller racing with Close() fails rather than reopening
+// it.
+func (r *Remote) sharedClient(ctx context.Context, connect bool) (*sshConn, error) {
+	r.connMu.Lock()
+	for r.connecting != nil {
+		connecting := r.connecting
+		r.connMu.Unlock()
+		<-connecting
+		r.connMu.Lock()
+	}
+	if !connect && !r.shared {
+		r.connMu.Unlock()
+		return nil, r.connError("session", ErrConnectionLost, errors.New("shared connection closed"))
+	}
+	reconnect := false
+	if r.client != nil && !r.client.alive() && r.ReconnectAttempts > 0 {
+		r.client.Close() // nolint
+		r.client = nil
+		reconnect = true
+	}
+	if client := r.client; client != nil {
+		r.connMu.Unlock()
+		return client, nil
+	}
+	connecting := make(chan struct{})
+	cancel := make(chan struct{})
+	r.connecting = connecting
+	r.connCancel = cancel
+	r.connMu.Unlock()
+
+	var client *sshConn
+	var err error
+	openCtx, stop := withCancelChan(ctx, cancel)
+	if reconnect {
+		client, err = r.reconnect(openCtx, cancel)
+	} else {
+		client, err = r.open(openCtx)
+	}
+	stop()
+
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	r.connecting = nil
+	r.connCancel = nil
+	close(connecting)
+	select {
+	case <-cancel:
+		// Close() was called while connecting.
+		if err == nil {
+			client.Close() // nolint
+		}
+		return nil, r.connError("dial", ErrConnectionLost, errors.New("closed while connecting"))
+	default:
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.shared = true
+	r.client = client
+	r.agentForwarded = false
+
+	return client, nil
+}
+
+// withCancelChan returns a copy of ctx that is also canceled once
+// cancel is closed. The returned function must be called to release
+// its resources.
+func withCancelChan(ctx context.Context, cancel <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, stop := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-cancel:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, stop
+}
+
+// Close closes the tunnels and the shared connection. Commands run
+// after Close() use a connection of their own again.
+func (r *Remote) Close() error {
+	r.connMu.Lock()
+	tunnels := r.tunnels
+	r.tunnels = nil
+	r.connMu.Unlock()
+	for t := range tunnels {
+		t.Close() // nolint
+	}
+
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	r.shared = false
+	if r.connCancel != nil {
+		close(r.connCancel)
+		r.connCancel = nil
+	}
+	if r.client == nil {
+		return nil
+	}
+	alive := r.client.alive()
+	err := r.client.Close()
+	if !alive {
+		// Closing a lost connection fails.
+		err = nil
+	}
+	r.client = nil
+	logAttrs(r.Logger, slog.LevelDebug, "ssh connection closed", r.logAttrs()...)
+
+	return err
+}
+
+// newSession returns a session on the shared connection if it is open
+// and own is false, and on a connection of its own opened with ctx
+// otherwise. The returned function closes the session and its own
+// connection.
+func (r *Remote) newSession(ctx context.Context, own bool) (*ssh.Session, *sshConn, func(), error) {
+	r.connMu.Lock()
+	shared := r.shared && !own
+	r.connMu.Unlock()
+	var client *sshConn
+	var err error
+	if shared {
+		client, err = r.sharedClient(ctx, false)
+	} else {
+		client, err = r.open(ctx)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil && shared && r.ReconnectAttempts > 0 && client.lostErr(lostConnectionWait) != nil {
+		// The connection was lost before it was noticed.
+		if client, err = r.sharedClient(ctx, false); err != nil {
+			return nil, nil, nil, err
+		}
+		session, err = client.NewSession()
+	}
+	if err != nil {
+		if lost := client.lostErr(lostConnectionWait); lost != nil {
+			err = r.connError("session", ErrConnectionLost, lost)
+		}
+		if !shared {
+			client.Close() // nolint
+		}
+		return nil, nil, nil, err
+	}
+	release := func() {
+		session.Close() // nolint
+		if !shared {
+			client.Close() // nolint
+		}
+	}
+	if r.AgentForwarding {
+		if err := r.forwardAgent(client, session, shared); err != nil {
+			release()
+			return nil, nil, nil, err
+		}
 	}
 
+	return session, client, release, nil
+}
+
+// forwardAgent forwards the agent listening on $SSH_AUTH_SOCK to the
+// session. The remote host connects to the agent through the SSH
+// connection, which opens a new connection to the local agent for each
+// of its requests, so no agent connection outlives the command.
+func (r *Remote) forwardAgent(client *sshConn, session *ssh.Session, shared bool) error {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return fmt.Errorf("run: cannot forward agent to %s@%s: SSH_AUTH_SOCK is not set",
+			r.Credentials.Username, r.Credentials.Hostname)
+	}
+	if err := r.registerAgent(client, sock, shared); err != nil {
+		return fmt.Errorf("run: cannot forward agent to %s@%s: %s",
+			r.Credentials.Username, r.Credentials.Hostname, err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("run: cannot forward agent to %s@%s: %s",
+			r.Credentials.Username, r.Credentials.Hostname, err)
+	}
+	logAttrs(r.Logger, slog.LevelDebug, "ssh agent forwarded", r.logAttrs()...)
+
 	return nil
 }
 
-func (r *Remote) close() error {
-	if r.sshSession != nil {
-		err := r.sshSession.Close()
-		r.sshSession = nil
+// registerAgent handles the agent channels opened by the host. The
+// handler can only be registered once per connection.
+func (r *Remote) registerAgent(client *sshConn, sock string, shared bool) error {
+	if !shared {
+		return agent.ForwardToRemote(client.Client, sock)
+	}
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	if r.agentForwarded && r.client == client {
+		return nil
+	}
+	if err := agent.ForwardToRemote(client.Client, sock); err != nil {
 		return err
 	}
+	r.agentForwarded = r.client == client
 
 	return nil
 }
 
+// ServerVersion returns the version string the host sent on the last
+// successful connection, e.g., "SSH-2.0-OpenSSH_7.4". It is empty if no
+// connection has been made.
+func (r *Remote) ServerVersion() string {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+
+	return r.serverVersion
+}
+
+// Banner returns the banner the host sent before authentication on the
+// last successful connection. It is empty if the host sent none.
+func (r *Remote) Banner() string {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+
+	return r.banner
+}
+
+// LastAuth returns the authentication method used by the last
+// successful connection. It is the zero AuthInfo if no connection has
+// been made.
+func (r *Remote) LastAuth() AuthInfo {
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+
+	return r.lastAuth
+}
+
 func (r *Remote) exec(args ...string) (string, string, int, error) {
-	err := r.open()
+	return r.execContext(context.Background(), false, r.Stdin, r.Stdout, r.Stderr, args...)
+}
+
+// execContext runs and logs a command. It uses a connection of its own
+// if own is true and closes the session when ctx is done. The output
+// is captured unless stdout or stderr are set.
+func (r *Remote) execContext(
+	ctx context.Context,
+	own bool,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+	args ...string) (string, string, int, error) {
+
+	clog := newCommandLog(r.Logger, r.LogOutputBytes, r.redact, r.redact(strings.Join(args, " ")), r.logAttrs()...)
+	outStr, errStr, code, err := r.execCmd(ctx, clog, own, stdin, stdout, stderr, args...)
+	err = r.redactError(err)
+	clog.done(outStr, errStr, code, err)
+
+	return outStr, errStr, code, err
+}
+
+func (r *Remote) execCmd(
+	ctx context.Context,
+	clog *commandLog,
+	own bool,
+	stdin io.Reader,
+	stdout, stderr io.Writer,
+	args ...string) (string, string, int, error) {
+
+	session, client, release, err := r.newSession(ctx, own)
 	if err != nil {
-		return "", "", 0, err
-	}
-	defer r.close() // nolint
-	if r.sshSession == nil {
-		panic("Session == nil")
+		return "", "", 0, notStarted(err)
 	}
+	defer release()
 
 	// Hook up standard files.
-	r.sshSession.Stdin = r.Stdin
+	session.Stdin = stdin
 	var stdoutPipe io.Reader
-	if r.Stdout == nil {
-		stdoutPipe, err = r.sshSession.StdoutPipe()
+	if stdout == nil {
+		stdoutPipe, err = session.StdoutPipe()
 		if err != nil {
-			return "", "", 0, err
+			return "", "", 0, notStarted(err)
 		}
 	} else {
-		r.sshSession.Stdout = r.Stdout
+		session.Stdout = clog.stdoutWriter(stdout)
 	}
 	var stderrPipe io.Reader
-	if r.Stderr == nil {
-		stderrPipe, err = r.sshSession.StderrPipe()
+	if stderr == nil {
+		stderrPipe, err = session.StderrPipe()
 		if err != nil {
-			return "", "", 0, err
+			return "", "", 0, notStarted(err)
 		}
 	} else {
-		r.sshSession.Stderr = r.Stderr
+		session.Stderr = clog.stderrWriter(stderr)
 	}
 
 	code := 0
 	cmdLine := strings.Join(args, " ")
-	err = r.sshSession.Run(cmdLine)
+	if err = session.Start(cmdLine); err != nil {
+		// The host may have received the command if the
+		// connection was lost while starting it.
+		if lost := client.lostErr(lostConnectionWait); lost != nil {
+			return "", "", 0, r.connError("session", ErrConnectionLost, lost)
+		}
+		return "", "", 0, notStarted(err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		session.Close() // nolint
+	})
+	defer stop()
+	err = session.Wait()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", "", 0, fmt.Errorf("run: %q did not finish on %s: %w", cmdLine, r.Credentials.Hostname, ctxErr)
+		}
 		switch err.(type) {
 		case *ssh.ExitError:
 			// Extract exit code from error message.
@@ -304,20 +974,23 @@ func (r *Remote) exec(args ...string) (string, string, int, error) {
 				}
 			}
 		default:
+			if lost := client.lostErr(lostConnectionWait); lost != nil {
+				err = r.connError("session", ErrConnectionLost, lost)
+			}
 			return "", "", 0, err
 		}
 	}
 
 	// Process the I/O.
 	var stdoutBuf []byte
-	if r.Stdout == nil {
+	if stdout == nil {
 		stdoutBuf, err = ioutil.ReadAll(stdoutPipe)
 		if err != nil {
 			return "", "", 0, err
 		}
 	}
 	var stderrBuf []byte
-	if r.Stderr == nil {
+	if stderr == nil {
 		stderrBuf, err = ioutil.ReadAll(stderrPipe)
 		if err != nil {
 			return "", "", 0, err
@@ -346,7 +1019,7 @@ func (r *Remote) FormatRun(cmd string, args ...string) string {
 		cmd,
 		strings.Join(args, " "))
 
-	return strings.TrimSpace(s)
+	return r.redact(strings.TrimSpace(s))
 }
 
 // Shell runs a command in a shell. The command is passed to the shell
@@ -369,5 +1042,5 @@ func (r *Remote) FormatShell(cmd string) string {
 		r.ShellExecutable,
 		cmd)
 
-	return strings.TrimSpace(s)
+	return r.redact(strings.TrimSpace(s))
 }
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"log/slog"
 	"regexp"
 	"strings"
 	"testing"
@@ -251,3 +252,65 @@ func TestRemote_FormatShell(t *testing.T) {
 		regexp.MustCompile(`ssh .*@.* /bin/sh -c "uname -a"`),
 		msg)
 }
+
+func TestRemote_Redactor(t *testing.T) {
+	redactor := run.NewRedactor()
+	require.NoError(t, redactor.AddFlag("--token=*"))
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials: run.Credentials{
+			Hostname: "localhost",
+			Username: "me",
+			Password: "s3cret",
+		},
+		Redactor: redactor,
+	})
+	require.NoError(t, err)
+
+	msg := r.FormatRun("curl", "--token", "abc", "https://example.com")
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, "ssh me@localhost curl --token ****** https://example.com", msg)
+
+	msg = r.FormatShell("echo s3cret | sudo -S true")
+	t.Logf("msg = %q", msg)
+	assert.Equal(t, `ssh me@localhost /bin/sh -c "echo ****** | sudo -S true"`, msg)
+	assert.Equal(t, "******", redactor.Redact("s3cret"))
+
+	// Passwords too short to hide are not registered and a warning
+	// is logged.
+	var b bytes.Buffer
+	redactor = run.NewRedactor()
+	r, err = run.NewRemote(run.RemoteConfig{
+		Credentials: run.Credentials{
+			Hostname: "localhost",
+			Username: "me",
+			Password: "root",
+		},
+		Logger:   slog.New(slog.NewJSONHandler(&b, nil)),
+		Redactor: redactor,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ssh me@localhost chown root /srv", r.FormatRun("chown", "root", "/srv"))
+	t.Logf("log = %s", b.String())
+	assert.Contains(t, b.String(), `"level":"WARN","msg":"ssh password too short to redact"`)
+	assert.NotContains(t, b.String(), `"root"`)
+}
+
+func TestRemote_PasswordSource(t *testing.T) {
+	read := false
+	r, err := run.NewRemote(run.RemoteConfig{
+		Credentials: run.Credentials{
+			Hostname: "localhost",
+			Username: "me",
+			PasswordSource: run.SecretFunc(func() ([]byte, error) {
+				read = true
+				return []byte("s3cret"), nil
+			}),
+		},
+	})
+	require.NoError(t, err)
+
+	// The password is not read by the constructor and no default
+	// key is used.
+	assert.False(t, read)
+	assert.Empty(t, r.Credentials.PrivateKeyFilename)
+}
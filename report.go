@@ -0,0 +1,180 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// OutputGroup is a set of hosts on which a command produced identical
+// standard out, standard error, exit code, and error.
+type OutputGroup struct {
+	// Hosts are the hosts in the group in the order they appear
+	// in the Results.
+	Hosts []string
+
+	// Stdout is the standard out shared by the hosts.
+	Stdout string
+
+	// Stderr is the standard error shared by the hosts.
+	Stderr string
+
+	// ExitCode is the exit code shared by the hosts.
+	ExitCode int
+
+	// Err is the error message shared by the hosts. It is empty if
+	// there was no error.
+	Err string
+}
+
+// Aggregate groups hosts with identical standard out, standard error,
+// exit code, and error. Groups are ordered from largest to smallest,
+// so the first group is the majority. Groups of the same size are in
+// the order of their first host.
+func (r *Results) Aggregate() []*OutputGroup {
+	type key struct {
+		stdout string
+		stderr string
+		code   int
+		err    string
+	}
+	var groups []*OutputGroup
+	index := make(map[key]*OutputGroup)
+	r.Each(func(host string, res *Result) {
+		if res == nil {
+			res = &Result{Err: ErrSkipped}
+		}
+		k := key{stdout: res.Stdout, stderr: res.Stderr, code: res.ExitCode}
+		if res.Err != nil {
+			k.err = res.Err.Error()
+		}
+		g := index[k]
+		if g == nil {
+			g = &OutputGroup{
+				Stdout:   k.stdout,
+				Stderr:   k.stderr,
+				ExitCode: k.code,
+				Err:      k.err,
+			}
+			index[k] = g
+			groups = append(groups, g)
+		}
+		g.Hosts = append(g.Hosts, host)
+	})
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+
+	return groups
+}
+
+// ReportConfig is used to configure WriteReport().
+type ReportConfig struct {
+	// Diff, if true, shows outlier groups as unified diffs of
+	// their output against the majority group instead of in
+	// full.
+	Diff bool
+
+	// Redactor hides secrets in the reported output and errors.
+	// DefaultRedactor is used if it is nil.
+	Redactor *Redactor
+}
+
+// WriteReport writes the output of a command run on many hosts to w,
+// grouping hosts with identical output like ClusterShell's clubak. Each
+// group is headed by its hosts folded into ranges by FoldHosts(), e.g.,
+// "app[01-12,15] (13)", followed by its standard out, standard error,
+// exit code, and error. If config.Diff is set, the first group is the
+// majority and the other groups are shown as differences from it.
+func WriteReport(w io.Writer, results *Results, config ReportConfig) error {
+	redactor := config.Redactor
+	if redactor == nil {
+		redactor = DefaultRedactor
+	}
+	groups := results.Aggregate()
+	var b strings.Builder
+	for i, g := range groups {
+		header := fmt.Sprintf("%s (%d)", FoldHosts(g.Hosts), len(g.Hosts))
+		sep := strings.Repeat("-", len(header))
+		fmt.Fprintf(&b, "%s\n%s\n%s\n", sep, header, sep)
+		if !config.Diff || i == 0 {
+			writeOutput(&b, "", redactor.Redact(g.Stdout))
+			writeOutput(&b, "stderr: ", redactor.Redact(g.Stderr))
+		} else {
+			majority := groups[0]
+			err := writeDiff(&b, "stdout", majority, g,
+				redactor.Redact(majority.Stdout), redactor.Redact(g.Stdout))
+			if err != nil {
+				return err
+			}
+			err = writeDiff(&b, "stderr", majority, g,
+				redactor.Redact(majority.Stderr), redactor.Redact(g.Stderr))
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(&b, "exit code: %d\n", g.ExitCode)
+		if g.Err != "" {
+			fmt.Fprintf(&b, "error: %s\n", redactor.Redact(g.Err))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// writeOutput writes the lines of s to b, each preceded by prefix.
+func writeOutput(b *strings.Builder, prefix string, s string) {
+	if s == "" {
+		return
+	}
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n") {
+		b.WriteString(prefix)
+		b.WriteString(strings.TrimSuffix(line, "\n"))
+		b.WriteString("\n")
+	}
+}
+
+// writeDiff writes a unified diff of the named output of group
+// against the majority group to b.
+func writeDiff(b *strings.Builder, name string, majority *OutputGroup, group *OutputGroup, from string, to string) error {
+	if from == to {
+		return nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(from),
+		B:        splitLines(to),
+		FromFile: FoldHosts(majority.Hosts) + " " + name,
+		ToFile:   FoldHosts(group.Hosts) + " " + name,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	b.WriteString(diff)
+	if !strings.HasSuffix(diff, "\n") {
+		b.WriteString("\n")
+	}
+
+	return nil
+}
+
+// splitLines splits s into lines each ending in a newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	lines := strings.SplitAfter(s, "\n")
+
+	return lines[:len(lines)-1]
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newReportResults runs a shell command on a group of Local runners
+// named app01...app06 with HOST set in their environment.
+func newReportResults(t *testing.T, cmd string) *run.Results {
+	g := run.NewGroup(run.GroupConfig{})
+	for i := 1; i <= 6; i++ {
+		host := fmt.Sprintf("app%02d", i)
+		g.Add(host, run.NewLocal(run.LocalConfig{
+			Env: []string{"HOST=" + host},
+		}))
+	}
+	results := g.ShellAll(cmd)
+	require.Equal(t, 6, results.Len())
+
+	return results
+}
+
+func TestResults_Aggregate(t *testing.T) {
+	results := newReportResults(t, `
+		case $HOST in
+		app03) echo 1.2.4 ;;
+		app05) echo oops >&2; exit 1 ;;
+		*) echo 1.2.3 ;;
+		esac`)
+	groups := results.Aggregate()
+
+	require.Len(t, groups, 3)
+	assert.Equal(t, []string{"app01", "app02", "app04", "app06"}, groups[0].Hosts)
+	assert.Equal(t, "1.2.3\n", groups[0].Stdout)
+	assert.Equal(t, []string{"app03"}, groups[1].Hosts)
+	assert.Equal(t, []string{"app05"}, groups[2].Hosts)
+	assert.Equal(t, "oops\n", groups[2].Stderr)
+	assert.Equal(t, 1, groups[2].ExitCode)
+}
+
+func TestWriteReport(t *testing.T) {
+	results := newReportResults(t, `
+		case $HOST in
+		app03) echo 1.2.4 ;;
+		app05) echo oops >&2; exit 1 ;;
+		*) echo 1.2.3 ;;
+		esac`)
+	var b strings.Builder
+	err := run.WriteReport(&b, results, run.ReportConfig{})
+	require.NoError(t, err)
+	t.Logf("report =\n%s", b.String())
+
+	assert.Equal(t, `--------------------
+app[01-02,04,06] (4)
+--------------------
+1.2.3
+exit code: 0
+---------
+app03 (1)
+---------
+1.2.4
+exit code: 0
+---------
+app05 (1)
+---------
+stderr: oops
+exit code: 1
+`, b.String())
+}
+
+func TestWriteReport_Diff(t *testing.T) {
+	redactor := run.NewRedactor()
+	redactor.AddLiteral("s3cret")
+	results := newReportResults(t, `
+		printf 'line 1\nline 2\n'
+		[ $HOST = app02 ] && echo "line 3 s3cret"
+		true`)
+	var b strings.Builder
+	err := run.WriteReport(&b, results, run.ReportConfig{
+		Diff:     true,
+		Redactor: redactor,
+	})
+	require.NoError(t, err)
+	t.Logf("report =\n%s", b.String())
+
+	assert.Equal(t, `-----------------
+app[01,03-06] (5)
+-----------------
+line 1
+line 2
+exit code: 0
+---------
+app02 (1)
+---------
+--- app[01,03-06] stdout
++++ app02 stdout
+@@ -1,2 +1,3 @@
+ line 1
+ line 2
++line 3 ******
+exit code: 0
+`, b.String())
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"time"
+)
+
+// Attempt records the outcome of a single execution of a command.
+type Attempt struct {
+	// Stdout is the captured standard out of the command.
+	Stdout string
+
+	// Stderr is the captured standard error of the command.
+	Stderr string
+
+	// ExitCode is the exit code of the command.
+	ExitCode int
+
+	// Err is the internal error, if any, returned when running
+	// the command. It is nil if the command ran, even if it
+	// exited with a non-zero exit code.
+	Err error
+
+	// Start is the time the attempt was started.
+	Start time.Time
+
+	// Duration is how long the attempt took to complete.
+	Duration time.Duration
+
+	// Delay is how long we waited before starting the attempt.
+	Delay time.Duration
+}
+
+// Failed returns true if the attempt returned an internal error or
+// the command exited with a non-zero exit code.
+func (a Attempt) Failed() bool {
+	return a.Err != nil || a.ExitCode != 0
+}
+
+// Result is the outcome of running a command, possibly over several
+// attempts. The Stdout, Stderr, ExitCode, and Err fields are those of
+// the last attempt.
+type Result struct {
+	// Stdout is the captured standard out of the command.
+	Stdout string
+
+	// Stderr is the captured standard error of the command.
+	Stderr string
+
+	// ExitCode is the exit code of the command.
+	ExitCode int
+
+	// Err is the internal error, if any, returned when running
+	// the command.
+	Err error
+
+	// Duration is the total time spent running the command
+	// including any delays between attempts.
+	Duration time.Duration
+
+	// Attempts contains every attempt made to run the command in
+	// the order they were made.
+	Attempts []Attempt
+}
+
+// Failed returns true if the command returned an internal error or
+// exited with a non-zero exit code.
+func (r *Result) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// resultRunner is implemented by runners that can return a full
+// Result rather than the individual values returned by the Runner
+// interface, e.g., Retrier.
+type resultRunner interface {
+	RunResult(cmd string, args ...string) *Result
+	ShellResult(cmd string) *Result
+}
+
+// runResult runs a command on runner and returns the outcome as a
+// Result.
+func runResult(runner Runner, cmd string, args ...string) *Result {
+	if rr, ok := runner.(resultRunner); ok {
+		return rr.RunResult(cmd, args...)
+	}
+
+	return newResult(attempt(0, func() (string, string, int, error) {
+		return runner.Run(cmd, args...)
+	}))
+}
+
+// shellResult runs a shell command on runner and returns the outcome
+// as a Result.
+func shellResult(runner Runner, cmd string) *Result {
+	if rr, ok := runner.(resultRunner); ok {
+		return rr.ShellResult(cmd)
+	}
+
+	return newResult(attempt(0, func() (string, string, int, error) {
+		return runner.Shell(cmd)
+	}))
+}
+
+// attempt runs fn once and records the outcome.
+func attempt(delay time.Duration, fn func() (string, string, int, error)) Attempt {
+	a := Attempt{
+		Start: time.Now(),
+		Delay: delay,
+	}
+	a.Stdout, a.Stderr, a.ExitCode, a.Err = fn()
+	a.Duration = time.Since(a.Start)
+
+	return a
+}
+
+// newResult returns a Result containing the given attempts.
+func newResult(attempts ...Attempt) *Result {
+	res := &Result{Attempts: attempts}
+	for _, a := range attempts {
+		res.Duration += a.Delay + a.Duration
+	}
+	if len(attempts) > 0 {
+		last := attempts[len(attempts)-1]
+		res.Stdout = last.Stdout
+		res.Stderr = last.Stderr
+		res.ExitCode = last.ExitCode
+		res.Err = last.Err
+	}
+
+	return res
+}
@@ -0,0 +1,321 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRetryAttempts is the maximum number of attempts
+	// made by a Retrier if RetryPolicy.MaxAttempts is not set.
+	DefaultRetryAttempts = 3
+
+	// DefaultInitialBackoff is the delay before the first retry
+	// if Backoff.Initial is not set.
+	DefaultInitialBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the longest delay between retries if
+	// Backoff.Max is not set.
+	DefaultMaxBackoff = 10 * time.Second
+
+	// DefaultBackoffMultiplier is the factor the delay grows by
+	// after each retry if Backoff.Multiplier is not set.
+	DefaultBackoffMultiplier = 2.0
+)
+
+// Backoff describes an exponential backoff with optional jitter.
+type Backoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+
+	// Max is the upper bound of the delay between retries.
+	Max time.Duration
+
+	// Multiplier is the factor the delay is multiplied by after
+	// each retry.
+	Multiplier float64
+
+	// Jitter is the fraction, between 0.0 and 1.0, of each delay
+	// that is randomized. A Jitter of 0.2 results in delays
+	// between 80% and 100% of the nominal delay. Zero disables
+	// jitter.
+	Jitter float64
+}
+
+// withDefaults returns a copy of b with unset fields set to their
+// default values.
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultInitialBackoff
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultMaxBackoff
+	}
+	if b.Multiplier < 1.0 {
+		b.Multiplier = DefaultBackoffMultiplier
+	}
+	if b.Jitter < 0.0 {
+		b.Jitter = 0.0
+	}
+	if b.Jitter > 1.0 {
+		b.Jitter = 1.0
+	}
+
+	return b
+}
+
+// Delay returns the delay before retry number n, where n = 1 is the
+// first retry. Unset fields use their default values.
+func (b Backoff) Delay(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	b = b.withDefaults()
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(n-1))
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0.0 {
+		d -= d * b.Jitter * rand.Float64() // nolint: gosec
+	}
+
+	return time.Duration(d)
+}
+
+// RetryPredicate decides whether a failed attempt should be retried.
+type RetryPredicate func(a Attempt) bool
+
+// RetryOnError returns a RetryPredicate that retries attempts whose
+// internal error satisfies match.
+func RetryOnError(match func(err error) bool) RetryPredicate {
+	return func(a Attempt) bool {
+		return a.Err != nil && match(a.Err)
+	}
+}
+
+// RetryOnTransientError returns a RetryPredicate that retries
+// attempts that failed with an error IsTransientError considers
+// transient.
+func RetryOnTransientError() RetryPredicate {
+	return RetryOnError(IsTransientError)
+}
+
+// RetryOnExitCode returns a RetryPredicate that retries attempts that
+// ran to completion but exited with one of the given exit codes.
+func RetryOnExitCode(codes ...int) RetryPredicate {
+	return func(a Attempt) bool {
+		if a.Err != nil {
+			return false
+		}
+		for _, code := range codes {
+			if a.ExitCode == code {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// RetryOnStderr returns a RetryPredicate that retries attempts that
+// exited with a non-zero exit code and whose standard error matches
+// re.
+func RetryOnStderr(re *regexp.Regexp) RetryPredicate {
+	return func(a Attempt) bool {
+		return a.Err == nil && a.ExitCode != 0 && re.MatchString(a.Stderr)
+	}
+}
+
+// transientErrorRe matches the text of errors that are usually
+// transient. It is used for errors that have lost their type by being
+// formatted into another error.
+var transientErrorRe = regexp.MustCompile(
+	`connection reset|connection refused|connection timed out|broken pipe|` +
+		`i/o timeout|no route to host|network is unreachable|unexpected EOF|` +
+		`handshake failed: EOF`)
+
+// IsTransientError returns true if err looks like a network error
+// that may go away if the operation is retried, e.g., a refused or
+// reset connection, a timeout, or a temporary DNS failure. Permanent
+// failures, such as unknown hosts, are not transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, target := range []error{
+		syscall.ECONNRESET,
+		syscall.ECONNREFUSED,
+		syscall.ECONNABORTED,
+		syscall.EHOSTUNREACH,
+		syscall.ENETUNREACH,
+		syscall.ETIMEDOUT,
+		syscall.EPIPE,
+		io.ErrUnexpectedEOF,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return transientErrorRe.MatchString(err.Error())
+}
+
+// RetryPolicy configures how a Retrier retries failed commands.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a command is
+	// run including the first attempt.
+	MaxAttempts int
+
+	// Backoff is the delay between attempts.
+	Backoff Backoff
+
+	// RetryIf is the list of predicates used to decide whether a
+	// failed attempt is retried. An attempt is retried if any of
+	// the predicates returns true.
+	RetryIf []RetryPredicate
+
+	// RetryShell enables retrying commands run with Shell().
+	// Shell commands are often not idempotent, e.g., they may
+	// append to a file, so they are only run once unless
+	// RetryShell is set.
+	RetryShell bool
+}
+
+// Retrier wraps a Runner to retry commands that fail in ways that are
+// likely to be transient. It fulfills the Runner interface, so it can
+// be used wherever Local or Remote are used.
+type Retrier struct {
+	// Runner is the runner used to run commands.
+	Runner Runner
+
+	// Policy determines which commands are retried and how.
+	Policy RetryPolicy
+}
+
+// NewRetrier is the constructor for Retrier. It takes the Runner to
+// wrap and a RetryPolicy to configure it. The following policy options
+// are set if the default RetryPolicy constructor, RetryPolicy{}, is
+// used:
+//
+//     MaxAttempts = DefaultRetryAttempts
+//     Backoff.Initial = DefaultInitialBackoff
+//     Backoff.Max = DefaultMaxBackoff
+//     Backoff.Multiplier = DefaultBackoffMultiplier
+//     Backoff.Jitter = 0.0 // No jitter.
+//     RetryIf = []RetryPredicate{RetryOnTransientError()}
+//     RetryShell = false   // Shell commands are not retried.
+func NewRetrier(runner Runner, policy RetryPolicy) *Retrier {
+	r := new(Retrier)
+	r.Runner = runner
+	r.Policy = policy
+	if r.Policy.MaxAttempts < 1 {
+		r.Policy.MaxAttempts = DefaultRetryAttempts
+	}
+	r.Policy.Backoff = r.Policy.Backoff.withDefaults()
+	if len(r.Policy.RetryIf) == 0 {
+		r.Policy.RetryIf = []RetryPredicate{RetryOnTransientError()}
+	}
+
+	return r
+}
+
+func (r *Retrier) shouldRetry(a Attempt) bool {
+	if !a.Failed() {
+		return false
+	}
+	for _, pred := range r.Policy.RetryIf {
+		if pred(a) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Retrier) do(maxAttempts int, fn func() (string, string, int, error)) *Result {
+	var attempts []Attempt
+	var delay time.Duration
+	for n := 1; ; n++ {
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		a := attempt(delay, fn)
+		attempts = append(attempts, a)
+		if n >= maxAttempts || !r.shouldRetry(a) {
+			break
+		}
+		delay = r.Policy.Backoff.Delay(n)
+	}
+
+	return newResult(attempts...)
+}
+
+// RunResult runs a command like Run(), retrying it according to the
+// policy. The returned Result records every attempt.
+func (r *Retrier) RunResult(cmd string, args ...string) *Result {
+	return r.do(r.Policy.MaxAttempts, func() (string, string, int, error) {
+		return r.Runner.Run(cmd, args...)
+	})
+}
+
+// ShellResult runs a command like Shell(), retrying it according to
+// the policy if RetryShell is set. The returned Result records every
+// attempt.
+func (r *Retrier) ShellResult(cmd string) *Result {
+	maxAttempts := 1
+	if r.Policy.RetryShell {
+		maxAttempts = r.Policy.MaxAttempts
+	}
+
+	return r.do(maxAttempts, func() (string, string, int, error) {
+		return r.Runner.Shell(cmd)
+	})
+}
+
+// Run runs a command like glibc's exec() call, retrying it according
+// to the policy. It returns the standard out, standard error, and
+// exit code of the last attempt.
+func (r *Retrier) Run(cmd string, args ...string) (string, string, int, error) {
+	res := r.RunResult(cmd, args...)
+
+	return res.Stdout, res.Stderr, res.ExitCode, res.Err
+}
+
+// FormatRun returns a string representation of the what command would
+// be run using Run(). Useful for logging commands.
+func (r *Retrier) FormatRun(cmd string, args ...string) string {
+	return r.Runner.FormatRun(cmd, args...)
+}
+
+// Shell runs a command in a shell, retrying it according to the
+// policy if RetryShell is set. It returns the standard out, standard
+// error, and exit code of the last attempt.
+func (r *Retrier) Shell(cmd string) (string, string, int, error) {
+	res := r.ShellResult(cmd)
+
+	return res.Stdout, res.Stderr, res.ExitCode, res.Err
+}
+
+// FormatShell returns a string representation of the what command
+// would be run using Shell(). Useful for logging commands.
+func (r *Retrier) FormatShell(cmd string) string {
+	return r.Runner.FormatShell(cmd)
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedRunner is a Runner that returns canned outcomes in order,
+// repeating the last one once the script is exhausted.
+type scriptedRunner struct {
+	script []run.Attempt
+	calls  int
+}
+
+func (s *scriptedRunner) next() (string, string, int, error) {
+	i := s.calls
+	if i >= len(s.script) {
+		i = len(s.script) - 1
+	}
+	s.calls++
+	a := s.script[i]
+
+	return a.Stdout, a.Stderr, a.ExitCode, a.Err
+}
+
+func (s *scriptedRunner) Run(cmd string, args ...string) (string, string, int, error) {
+	return s.next()
+}
+
+func (s *scriptedRunner) FormatRun(cmd string, args ...string) string {
+	return cmd
+}
+
+func (s *scriptedRunner) Shell(cmd string) (string, string, int, error) {
+	return s.next()
+}
+
+func (s *scriptedRunner) FormatShell(cmd string) string {
+	return cmd
+}
+
+var fastBackoff = run.Backoff{
+	Initial: time.Millisecond,
+	Max:     5 * time.Millisecond,
+}
+
+func TestRetrier_TransientError(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{Err: fmt.Errorf("run: connection to me@host failed: %s", syscall.ECONNRESET)},
+		{Err: syscall.ECONNREFUSED},
+		{Stdout: "ok\n"},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{Backoff: fastBackoff})
+	res := r.RunResult("/bin/true")
+
+	assert.Equal(t, 3, s.calls)
+	assert.Len(t, res.Attempts, 3)
+	assert.Equal(t, "ok\n", res.Stdout)
+	assert.NoError(t, res.Err)
+	assert.False(t, res.Failed())
+	assert.Zero(t, res.Attempts[0].Delay)
+	assert.Equal(t, time.Millisecond, res.Attempts[1].Delay)
+	assert.Equal(t, 2*time.Millisecond, res.Attempts[2].Delay)
+}
+
+func TestRetrier_PermanentError(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{Err: errors.New("ssh: handshake failed: ssh: unable to authenticate")},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{Backoff: fastBackoff})
+	_, _, _, err := r.Run("/bin/true")
+
+	assert.Equal(t, 1, s.calls)
+	assert.Error(t, err)
+}
+
+func TestRetrier_MaxAttempts(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{Err: syscall.ECONNRESET},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{
+		MaxAttempts: 4,
+		Backoff:     fastBackoff,
+	})
+	res := r.RunResult("/bin/true")
+
+	assert.Equal(t, 4, s.calls)
+	assert.Len(t, res.Attempts, 4)
+	assert.True(t, errors.Is(res.Err, syscall.ECONNRESET))
+}
+
+func TestRetrier_ExitCode(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{ExitCode: 75},
+		{ExitCode: 1},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{
+		Backoff: fastBackoff,
+		RetryIf: []run.RetryPredicate{run.RetryOnExitCode(75)},
+	})
+	_, _, code, err := r.Run("/bin/true")
+
+	assert.Equal(t, 2, s.calls)
+	assert.Equal(t, 1, code)
+	assert.NoError(t, err)
+}
+
+func TestRetrier_Stderr(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{ExitCode: 1, Stderr: "Could not get lock /var/lib/dpkg/lock\n"},
+		{ExitCode: 1, Stderr: "E: Unable to locate package xyzzy\n"},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{
+		Backoff: fastBackoff,
+		RetryIf: []run.RetryPredicate{
+			run.RetryOnStderr(regexp.MustCompile(`Could not get lock`)),
+		},
+	})
+	res := r.RunResult("apt-get", "install", "xyzzy")
+
+	assert.Len(t, res.Attempts, 2)
+	assert.Equal(t, "E: Unable to locate package xyzzy\n", res.Stderr)
+}
+
+func TestRetrier_Shell(t *testing.T) {
+	s := &scriptedRunner{script: []run.Attempt{
+		{Err: syscall.ECONNRESET},
+		{Stdout: "ok\n"},
+	}}
+	r := run.NewRetrier(s, run.RetryPolicy{Backoff: fastBackoff})
+	res := r.ShellResult("echo ok >> /tmp/log")
+	assert.Equal(t, 1, s.calls, "Shell commands retried by default")
+	assert.Len(t, res.Attempts, 1)
+	assert.Error(t, res.Err)
+
+	s = &scriptedRunner{script: s.script}
+	r = run.NewRetrier(s, run.RetryPolicy{
+		Backoff:    fastBackoff,
+		RetryShell: true,
+	})
+	res = r.ShellResult("echo ok >> /tmp/log")
+	assert.Equal(t, 2, s.calls)
+	assert.Len(t, res.Attempts, 2)
+	assert.Equal(t, "ok\n", res.Stdout)
+}
+
+func TestRetrier_Local(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	r := run.NewRetrier(run.NewLocal(run.LocalConfig{}), run.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     fastBackoff,
+		RetryIf:     []run.RetryPredicate{run.RetryOnExitCode(75)},
+		RetryShell:  true,
+	})
+	stdout, stderr, code, err := r.Shell(fmt.Sprintf(
+		`n=$(cat %[1]s 2>/dev/null || echo 0); n=$((n+1)); echo $n > %[1]s; `+
+			`[ $n -ge 3 ] || exit 75; echo $n`, counter))
+	t.Logf("stdout = %q", stdout)
+	t.Logf("stderr = %q", stderr)
+	t.Logf("code = %d", code)
+
+	require.NoError(t, err)
+	assert.Equal(t, "3\n", stdout)
+	assert.Zero(t, code)
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := run.Backoff{
+		Initial:    10 * time.Millisecond,
+		Max:        50 * time.Millisecond,
+		Multiplier: 3,
+	}
+	assert.Zero(t, b.Delay(0))
+	assert.Equal(t, 10*time.Millisecond, b.Delay(1))
+	assert.Equal(t, 30*time.Millisecond, b.Delay(2))
+	assert.Equal(t, 50*time.Millisecond, b.Delay(3))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(2)
+		assert.True(t, d > 15*time.Millisecond && d <= 30*time.Millisecond, "delay %s", d)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.False(t, run.IsTransientError(nil))
+	assert.False(t, run.IsTransientError(errors.New("ssh: unable to authenticate")))
+	assert.True(t, run.IsTransientError(syscall.ECONNRESET))
+	assert.True(t, run.IsTransientError(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)))
+	assert.True(t, run.IsTransientError(errors.New(
+		"run: connection to me@host failed: dial tcp 10.0.0.1:22: connect: connection refused")))
+
+	// Only timeouts and temporary DNS failures among net.Errors.
+	assert.True(t, run.IsTransientError(&net.OpError{Op: "dial", Err: &timeoutError{}}))
+	assert.False(t, run.IsTransientError(&net.OpError{Op: "dial", Err: errors.New("invalid argument")}))
+	assert.True(t, run.IsTransientError(&net.DNSError{Err: "server misbehaving", Name: "host", IsTemporary: true}))
+	assert.True(t, run.IsTransientError(&net.DNSError{Err: "i/o timeout", Name: "host", IsTimeout: true}))
+	notFound := &net.DNSError{Err: "no such host", Name: "host", IsNotFound: true}
+	assert.False(t, run.IsTransientError(notFound))
+	assert.False(t, run.IsTransientError(&net.OpError{Op: "dial", Err: notFound}))
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
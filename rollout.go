@@ -0,0 +1,260 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRolloutStopped is wrapped by the error returned in
+// RolloutResult.Err when a rollout is stopped before all batches were
+// run.
+var ErrRolloutStopped = errors.New("run: rollout stopped")
+
+// RolloutConfig is used to configure the Rollout constructor.
+type RolloutConfig struct {
+	// BatchSize is the number of hosts in each batch. See Rollout
+	// for details.
+	BatchSize int
+
+	// BatchPercent is the size of each batch as a percentage of
+	// the hosts. See Rollout for details.
+	BatchPercent int
+
+	// CanarySize is the number of hosts in the first batch. See
+	// Rollout for details.
+	CanarySize int
+
+	// Pause is the delay between batches. See Rollout for
+	// details.
+	Pause time.Duration
+
+	// HealthCheck is a shell command run on each host after the
+	// command. See Rollout for details.
+	HealthCheck string
+
+	// MaxFailures is the number of failed hosts tolerated before
+	// the rollout is stopped. See Rollout for details.
+	MaxFailures int
+}
+
+// Rollout runs a command on the hosts of a Group in batches, stopping
+// when too many hosts fail. Within a batch, commands are run using
+// the Group's concurrency limit, timeout, fail-fast mode, and progress
+// reporting.
+type Rollout struct {
+	// Group contains the hosts to run commands on. Batches are
+	// formed in the order hosts were added to the Group.
+	Group *Group
+
+	// BatchSize is the number of hosts in each batch.
+	BatchSize int
+
+	// BatchPercent is the size of each batch as a percentage,
+	// from 1 to 100, of the number of hosts in the Group, rounded
+	// up. It is used only if BatchSize is zero.
+	BatchPercent int
+
+	// CanarySize is the number of hosts in the first batch. The
+	// rollout is stopped if any of them fail, regardless of
+	// MaxFailures. Zero means there is no canary batch.
+	CanarySize int
+
+	// Pause is the time to wait between batches.
+	Pause time.Duration
+
+	// HealthCheck, if not empty, is a shell command run on every
+	// host of a batch on which the command succeeded. A host
+	// whose health check fails is counted as a failed host. The
+	// next batch is started only after all health checks have
+	// completed.
+	HealthCheck string
+
+	// MaxFailures is the number of failed hosts tolerated over the
+	// whole rollout. The rollout is stopped after the batch in
+	// which the number of failed hosts exceeds MaxFailures. A
+	// negative value tolerates any number of failures.
+	MaxFailures int
+}
+
+// RolloutResult is the outcome of a rollout.
+type RolloutResult struct {
+	// Results contains the outcome of the command on every host
+	// in the Group. Hosts in batches that were not run because
+	// the rollout was stopped have a Result with the ErrSkipped
+	// error.
+	Results *Results
+
+	// HealthChecks contains the outcome of the health check on
+	// every host it was run on.
+	HealthChecks *Results
+
+	// Batches contains the hosts in each batch, including those
+	// that were not run.
+	Batches [][]string
+
+	// Completed is the number of batches that were run.
+	Completed int
+
+	// Failed contains the hosts, in order, on which the command
+	// or the health check failed.
+	Failed []string
+
+	// Err is nil if every batch was run, otherwise it wraps
+	// ErrRolloutStopped and describes why the rollout stopped.
+	Err error
+}
+
+// NewRollout is the constructor for Rollout. It takes the Group
+// containing the hosts and a RolloutConfig object to configure it.
+// The following configuration options are set if the default
+// RolloutConfig constructor, RolloutConfig{}, is used:
+//
+//     BatchSize = 1
+//     BatchPercent = 0
+//     CanarySize = 0   // No canary batch.
+//     Pause = 0        // Start the next batch immediately.
+//     HealthCheck = "" // No health check.
+//     MaxFailures = 0  // Stop after the first failed host.
+func NewRollout(group *Group, config RolloutConfig) *Rollout {
+	r := new(Rollout)
+	r.Group = group
+	r.BatchSize = config.BatchSize
+	r.BatchPercent = config.BatchPercent
+	if r.BatchSize < 1 && (r.BatchPercent < 1 || r.BatchPercent > 100) {
+		r.BatchSize = 1
+	}
+	r.CanarySize = config.CanarySize
+	r.Pause = config.Pause
+	r.HealthCheck = config.HealthCheck
+	r.MaxFailures = config.MaxFailures
+
+	return r
+}
+
+// Batches returns the hosts in each batch in the order they will be
+// run.
+func (r *Rollout) Batches() [][]string {
+	hosts := r.Group.Hosts()
+	size := r.BatchSize
+	if size < 1 {
+		size = (len(hosts)*r.BatchPercent + 99) / 100
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	var batches [][]string
+	if r.CanarySize > 0 && len(hosts) > 0 {
+		n := r.CanarySize
+		if n > len(hosts) {
+			n = len(hosts)
+		}
+		batches = append(batches, hosts[:n])
+		hosts = hosts[n:]
+	}
+	for len(hosts) > 0 {
+		n := size
+		if n > len(hosts) {
+			n = len(hosts)
+		}
+		batches = append(batches, hosts[:n])
+		hosts = hosts[n:]
+	}
+
+	return batches
+}
+
+// RunAll runs a command like glibc's exec() call on every host of the
+// Group, one batch at a time.
+func (r *Rollout) RunAll(cmd string, args ...string) *RolloutResult {
+	return r.do(func(runner Runner) *Result {
+		return runResult(runner, cmd, args...)
+	})
+}
+
+// ShellAll runs a command in a shell on every host of the Group, one
+// batch at a time.
+func (r *Rollout) ShellAll(cmd string) *RolloutResult {
+	return r.do(func(runner Runner) *Result {
+		return shellResult(runner, cmd)
+	})
+}
+
+func (r *Rollout) do(fn func(Runner) *Result) *RolloutResult {
+	rr := &RolloutResult{
+		Results:      newResults(r.Group.Hosts()),
+		HealthChecks: newResults(nil),
+		Batches:      r.Batches(),
+	}
+	failures := 0
+	for i, batch := range rr.Batches {
+		if rr.Err != nil {
+			for _, host := range batch {
+				rr.Results.set(host, &Result{Err: ErrSkipped})
+			}
+			continue
+		}
+		if i > 0 && r.Pause > 0 {
+			time.Sleep(r.Pause)
+		}
+
+		results := r.Group.do(batch, fn)
+		var healthy []string
+		results.Each(func(host string, res *Result) {
+			rr.Results.set(host, res)
+			if res.Failed() {
+				rr.Failed = append(rr.Failed, host)
+			} else {
+				healthy = append(healthy, host)
+			}
+		})
+		if r.HealthCheck != "" && len(healthy) > 0 {
+			checks := r.Group.do(healthy, func(runner Runner) *Result {
+				return shellResult(runner, r.HealthCheck)
+			})
+			checks.Each(func(host string, res *Result) {
+				rr.HealthChecks.add(host, res)
+				if res.Failed() {
+					rr.Failed = append(rr.Failed, host)
+				}
+			})
+		}
+		rr.Completed++
+
+		batchFailures := len(rr.Failed) - failures
+		failures = len(rr.Failed)
+		switch {
+		case i == 0 && r.CanarySize > 0 && batchFailures > 0:
+			rr.Err = fmt.Errorf("%w: %d of %d canary hosts failed",
+				ErrRolloutStopped, batchFailures, len(batch))
+		case r.MaxFailures >= 0 && failures > r.MaxFailures:
+			rr.Err = fmt.Errorf("%w: %d hosts failed after %d of %d batches, at most %d allowed",
+				ErrRolloutStopped, failures, rr.Completed, len(rr.Batches), r.MaxFailures)
+		}
+	}
+	rr.Failed = orderHosts(r.Group.hosts, rr.Failed)
+
+	return rr
+}
+
+// orderHosts returns the subset of hosts in all that are in subset, in
+// the order they appear in all.
+func orderHosts(all []string, subset []string) []string {
+	in := make(map[string]bool, len(subset))
+	for _, host := range subset {
+		in[host] = true
+	}
+	var hosts []string
+	for _, host := range all {
+		if in[host] {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRolloutGroup returns a Group of count Local runners named
+// host01...hostNN with HOST set in their environment.
+func newRolloutGroup(count int) *run.Group {
+	g := run.NewGroup(run.GroupConfig{})
+	for i := 1; i <= count; i++ {
+		host := fmt.Sprintf("host%02d", i)
+		g.Add(host, run.NewLocal(run.LocalConfig{
+			Env: []string{"HOST=" + host},
+		}))
+	}
+
+	return g
+}
+
+func TestRollout_Batches(t *testing.T) {
+	r := run.NewRollout(newRolloutGroup(7), run.RolloutConfig{BatchSize: 3})
+	assert.Equal(t, [][]string{
+		{"host01", "host02", "host03"},
+		{"host04", "host05", "host06"},
+		{"host07"},
+	}, r.Batches())
+
+	r = run.NewRollout(newRolloutGroup(10), run.RolloutConfig{
+		BatchPercent: 25,
+		CanarySize:   1,
+	})
+	assert.Equal(t, [][]string{
+		{"host01"},
+		{"host02", "host03", "host04"},
+		{"host05", "host06", "host07"},
+		{"host08", "host09", "host10"},
+	}, r.Batches())
+
+	r = run.NewRollout(newRolloutGroup(3), run.RolloutConfig{})
+	assert.Len(t, r.Batches(), 3)
+}
+
+func TestRollout_Success(t *testing.T) {
+	r := run.NewRollout(newRolloutGroup(5), run.RolloutConfig{
+		BatchSize:   2,
+		CanarySize:  1,
+		Pause:       10 * time.Millisecond,
+		HealthCheck: `echo "$HOST is healthy"`,
+	})
+	start := time.Now()
+	res := r.ShellAll(`echo "$HOST"`)
+
+	require.NoError(t, res.Err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(t, 3, res.Completed)
+	assert.Empty(t, res.Failed)
+	assert.Len(t, res.Results.Succeeded(), 5)
+	assert.Equal(t, "host03\n", res.Results.Get("host03").Stdout)
+	assert.Equal(t, "host04 is healthy\n", res.HealthChecks.Get("host04").Stdout)
+}
+
+func TestRollout_Canary(t *testing.T) {
+	r := run.NewRollout(newRolloutGroup(5), run.RolloutConfig{
+		BatchSize:   2,
+		CanarySize:  1,
+		MaxFailures: 3,
+	})
+	res := r.ShellAll(`[ "$HOST" != host01 ]`)
+	t.Logf("err = %s", res.Err)
+
+	require.Error(t, res.Err)
+	assert.True(t, errors.Is(res.Err, run.ErrRolloutStopped))
+	assert.Equal(t, 1, res.Completed)
+	assert.Equal(t, []string{"host01"}, res.Failed)
+	assert.Equal(t, run.ErrSkipped, res.Results.Get("host05").Err)
+}
+
+func TestRollout_MaxFailures(t *testing.T) {
+	r := run.NewRollout(newRolloutGroup(8), run.RolloutConfig{
+		BatchSize:   2,
+		HealthCheck: `[ "$HOST" != host04 ]`,
+		MaxFailures: 1,
+	})
+	res := r.RunAll("/bin/sh", "-c", `[ "$HOST" != host01 ]`)
+	t.Logf("err = %s", res.Err)
+
+	require.Error(t, res.Err)
+	assert.True(t, errors.Is(res.Err, run.ErrRolloutStopped))
+	assert.Equal(t, 2, res.Completed)
+	assert.Equal(t, []string{"host01", "host04"}, res.Failed)
+	assert.Nil(t, res.HealthChecks.Get("host01"), "Health check run on failed host")
+	assert.Equal(t, 1, res.HealthChecks.Get("host04").ExitCode)
+	assert.NoError(t, res.Results.Get("host04").Err)
+	for _, host := range []string{"host05", "host06", "host07", "host08"} {
+		assert.Equal(t, run.ErrSkipped, res.Results.Get(host).Err)
+	}
+}
+
+func TestRollout_Unlimited(t *testing.T) {
+	r := run.NewRollout(newRolloutGroup(4), run.RolloutConfig{
+		BatchSize:   1,
+		MaxFailures: -1,
+	})
+	res := r.ShellAll("exit 1")
+
+	assert.NoError(t, res.Err)
+	assert.Equal(t, 4, res.Completed)
+	assert.Len(t, res.Failed, 4)
+}
@@ -0,0 +1,256 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// SecretSource provides a secret, e.g., a password, when it is
+// needed rather than when the configuration is created.
+type SecretSource interface {
+	// Secret returns the secret. The caller owns the returned
+	// slice and should zero it with ZeroSecret() when done.
+	Secret() ([]byte, error)
+}
+
+// SecretFunc is an adapter to allow the use of an ordinary function as
+// a SecretSource.
+type SecretFunc func() ([]byte, error)
+
+// Secret calls f().
+func (f SecretFunc) Secret() ([]byte, error) {
+	return f()
+}
+
+// ZeroSecret overwrites secret with zeros.
+func ZeroSecret(secret []byte) {
+	for i := range secret {
+		secret[i] = 0
+	}
+}
+
+// trimNewline removes trailing newlines from secret in place.
+func trimNewline(secret []byte) []byte {
+	return bytes.TrimRight(secret, "\r\n")
+}
+
+// FileSecret is a SecretSource that reads the secret from a file.
+// Trailing newlines are removed.
+type FileSecret string
+
+// Secret reads the file.
+func (f FileSecret) Secret() ([]byte, error) {
+	secret, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot read secret: %w", err)
+	}
+
+	return trimNewline(secret), nil
+}
+
+// EnvSecret is a SecretSource that reads the secret from the named
+// environment variable. It is an error if the variable is not set.
+type EnvSecret string
+
+// Secret reads the environment variable.
+func (e EnvSecret) Secret() ([]byte, error) {
+	value, ok := os.LookupEnv(string(e))
+	if !ok {
+		return nil, fmt.Errorf("run: cannot read secret: environment variable %s is not set", string(e))
+	}
+
+	return []byte(value), nil
+}
+
+// CommandSecret is a SecretSource that runs a command on the local
+// host and uses its standard out, with trailing newlines removed, as
+// the secret, e.g., "pass show deploy".
+type CommandSecret struct {
+	// Local runs the command. It must capture standard out, i.e.,
+	// Local.Stdout must be nil. A Local created with the default
+	// LocalConfig is used if it is nil.
+	Local *Local
+
+	// Cmd is the command run in the shell.
+	Cmd string
+}
+
+// Secret runs the command. It is an error if the command exits with a
+// non-zero exit code or prints nothing.
+func (c *CommandSecret) Secret() ([]byte, error) {
+	local := c.Local
+	if local == nil {
+		local = NewLocal(LocalConfig{})
+	}
+	stdout, stderr, code, err := local.Shell(c.Cmd)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot read secret from %s: %w", local.FormatShell(c.Cmd), err)
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("run: cannot read secret from %s: exit code %d: %s",
+			local.FormatShell(c.Cmd), code, local.Redactor.Redact(strings.TrimSpace(stderr)))
+	}
+	secret := trimNewline([]byte(stdout))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("run: cannot read secret from %s: no output", local.FormatShell(c.Cmd))
+	}
+
+	return secret, nil
+}
+
+// encryptedSecretHeader starts the contents of files written by
+// EncryptSecret().
+const encryptedSecretHeader = "gorun-secret-v1:"
+
+// scrypt parameters used to derive the encryption key.
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+)
+
+// ErrBadPassphrase is returned when an encrypted secret cannot be
+// decrypted, usually because the passphrase is wrong.
+var ErrBadPassphrase = errors.New("run: cannot decrypt secret: wrong passphrase or corrupt data")
+
+// EncryptSecret encrypts secret with a key derived from passphrase
+// using scrypt and returns it in the text format read by
+// EncryptedFileSecret. The secret is encrypted with AES-256-GCM.
+func EncryptSecret(secret []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	data := append(append([]byte(nil), salt...), nonce...)
+	data = aead.Seal(data, nonce, secret, []byte(encryptedSecretHeader))
+
+	return []byte(encryptedSecretHeader + base64.StdEncoding.EncodeToString(data) + "\n"), nil
+}
+
+// DecryptSecret decrypts data written by EncryptSecret().
+func DecryptSecret(data []byte, passphrase []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, encryptedSecretHeader) {
+		return nil, errors.New("run: cannot decrypt secret: not an encrypted secret")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedSecretHeader))
+	if err != nil || len(raw) < scryptSaltLen {
+		return nil, ErrBadPassphrase
+	}
+	aead, err := secretCipher(passphrase, raw[:scryptSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[scryptSaltLen:]
+	if len(raw) < aead.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	secret, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(encryptedSecretHeader))
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	return secret, nil
+}
+
+// secretCipher returns the AEAD used to encrypt secrets.
+func secretCipher(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroSecret(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// EncryptedFileSecret is a SecretSource that decrypts a file written
+// by EncryptSecret(). The passphrase is itself provided by a
+// SecretSource, e.g., an EnvSecret or a CommandSecret that prompts
+// for it.
+type EncryptedFileSecret struct {
+	// Filename is the encrypted file.
+	Filename string
+
+	// Passphrase provides the passphrase the file was encrypted
+	// with.
+	Passphrase SecretSource
+}
+
+// Secret reads and decrypts the file.
+func (e *EncryptedFileSecret) Secret() ([]byte, error) {
+	data, err := ioutil.ReadFile(e.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot read secret: %w", err)
+	}
+	if e.Passphrase == nil {
+		return nil, fmt.Errorf("run: cannot decrypt secret %s: no passphrase", e.Filename)
+	}
+	passphrase, err := e.Passphrase.Secret()
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroSecret(passphrase)
+	secret, err := DecryptSecret(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, e.Filename)
+	}
+
+	return secret, nil
+}
+
+// ParseSecretRef returns the SecretSource for a secret reference:
+//
+//     env:NAME       An EnvSecret for the environment variable NAME.
+//     file:/path     A FileSecret for the file.
+//     cmd:command    A CommandSecret running the shell command.
+//
+// ParseSecretRef does not read the secret.
+func ParseSecretRef(ref string) (SecretSource, error) {
+	i := strings.IndexByte(ref, ':')
+	if i < 0 {
+		return nil, errors.New("run: secret reference must be env:NAME, file:/path, or cmd:command")
+	}
+	kind, value := ref[:i], ref[i+1:]
+	if value == "" {
+		return nil, fmt.Errorf("run: secret reference %q is empty", ref)
+	}
+	switch kind {
+	case "env":
+		return EnvSecret(value), nil
+	case "file":
+		return FileSecret(value), nil
+	case "cmd":
+		return &CommandSecret{Cmd: value}, nil
+	default:
+		return nil, fmt.Errorf("run: unknown secret reference type %q, must be env, file, or cmd", kind)
+	}
+}
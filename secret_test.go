@@ -0,0 +1,118 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("s3cret\n"), 0600))
+
+	secret, err := run.FileSecret(filename).Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+	run.ZeroSecret(secret)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0}, secret)
+
+	_, err = run.FileSecret(filepath.Join(dir, "missing")).Secret()
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
+
+func TestEnvSecret(t *testing.T) {
+	defer setenv(t, map[string]string{"TEST_SECRET": "s3cret"})()
+
+	secret, err := run.EnvSecret("TEST_SECRET").Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+
+	_, err = run.EnvSecret("TEST_NO_SUCH_SECRET").Secret()
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
+
+func TestCommandSecret(t *testing.T) {
+	secret, err := (&run.CommandSecret{Cmd: "echo s3cret"}).Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+
+	source := &run.CommandSecret{
+		Local: run.NewLocal(run.LocalConfig{Env: []string{"PASSWORD=s3cret"}}),
+		Cmd:   `printf '%s' "$PASSWORD"`,
+	}
+	secret, err = source.Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+
+	for _, cmd := range []string{"echo 'no such entry' >&2; exit 1", "true"} {
+		_, err = (&run.CommandSecret{Cmd: cmd}).Secret()
+		t.Logf("err = %v", err)
+		assert.Error(t, err, cmd)
+	}
+}
+
+func TestEncryptedFileSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data, err := run.EncryptSecret([]byte("s3cret"), []byte("passphrase"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cret")
+	filename := filepath.Join(dir, "password.enc")
+	require.NoError(t, ioutil.WriteFile(filename, data, 0600))
+
+	source := &run.EncryptedFileSecret{
+		Filename: filename,
+		Passphrase: run.SecretFunc(func() ([]byte, error) {
+			return []byte("passphrase"), nil
+		}),
+	}
+	secret, err := source.Secret()
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(secret))
+
+	source.Passphrase = run.SecretFunc(func() ([]byte, error) {
+		return []byte("wrong"), nil
+	})
+	_, err = source.Secret()
+	t.Logf("err = %v", err)
+	assert.True(t, errors.Is(err, run.ErrBadPassphrase))
+
+	_, err = run.DecryptSecret([]byte("s3cret"), []byte("passphrase"))
+	t.Logf("err = %v", err)
+	assert.Error(t, err)
+}
+
+func TestParseSecretRef(t *testing.T) {
+	for ref, expected := range map[string]run.SecretSource{
+		"env:PASSWORD":         run.EnvSecret("PASSWORD"),
+		"file:/etc/password":   run.FileSecret("/etc/password"),
+		"cmd:pass show deploy": &run.CommandSecret{Cmd: "pass show deploy"},
+	} {
+		source, err := run.ParseSecretRef(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, expected, source, ref)
+	}
+
+	for _, ref := range []string{"s3cret", "env:", "vault:deploy"} {
+		_, err := run.ParseSecretRef(ref)
+		t.Logf("err = %v", err)
+		assert.Error(t, err, ref)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// socksHandshakeTimeout limits the time a SOCKS client may take to send
+// its request.
+const socksHandshakeTimeout = 30 * time.Second
+
+// SOCKS5 protocol constants from RFC 1928.
+const (
+	socksVersion         = 5
+	socksNoAuth          = 0x00
+	socksUserPass        = 0x02
+	socksUserPassVersion = 0x01
+	socksNoAcceptable    = 0xff
+	socksConnect         = 0x01
+	socksAddrIPv4        = 0x01
+	socksAddrDomain      = 0x03
+	socksAddrIPv6        = 0x04
+	socksSucceeded       = 0x00
+	socksGeneralFailure  = 0x01
+	socksNotAllowed      = 0x02
+	socksRefused         = 0x05
+	socksCmdNotSupported = 0x07
+	socksAddrNotSupport  = 0x08
+)
+
+// ServeSOCKS listens on listenAddr on the local host and serves SOCKS5
+// CONNECT requests, like "ssh -D". The requested connections are made
+// from the remote host through the shared connection, so browsers and
+// other tools configured to use the proxy can reach the networks behind
+// it. Host names are resolved by the remote host. Only clients that do
+// not authenticate are accepted, so listen on a loopback address. The
+// returned Tunnel counts the bytes forwarded and reports failed
+// requests.
+func (r *Remote) ServeSOCKS(listenAddr string) (*Tunnel, error) {
+	if err := r.Connect(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot serve SOCKS on %s: %w", listenAddr, err)
+	}
+	name := fmt.Sprintf("socks %s -> remote", listener.Addr())
+	t := newTunnel(r, name, listener, r.socksConnect)
+	r.addTunnel(t)
+
+	return t, nil
+}
+
+// socksConnect reads the SOCKS5 request on conn and connects to the
+// requested address.
+func (r *Remote) socksConnect(conn net.Conn) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout)) // nolint
+	addr, err := socksHandshake(conn)
+	if err != nil {
+		return nil, fmt.Errorf("socks: %w", err)
+	}
+	target, err := r.Dial("tcp", addr)
+	if err != nil {
+		reply := byte(socksGeneralFailure)
+		var oerr *ssh.OpenChannelError
+		if errors.As(err, &oerr) {
+			switch oerr.Reason {
+			case ssh.Prohibited:
+				reply = socksNotAllowed
+			case ssh.ConnectionFailed:
+				reply = socksRefused
+			}
+		}
+		socksReply(conn, reply) // nolint
+		return nil, err
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		target.Close() // nolint
+		return nil, fmt.Errorf("socks: %w", err)
+	}
+	conn.SetDeadline(time.Time{}) // nolint
+
+	return target, nil
+}
+
+// socksHandshake negotiates the authentication method and reads a
+// CONNECT request. It returns the requested address.
+func socksHandshake(conn net.Conn) (string, error) {
+	// Version and authentication methods.
+	buf := make([]byte, 2, 256)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != socksVersion {
+		return "", fmt.Errorf("unsupported version %d", buf[0])
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", errors.New("client requires authentication")
+	}
+
+	// Request.
+	buf = buf[:4]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	if buf[0] != socksVersion {
+		return "", fmt.Errorf("unsupported version %d", buf[0])
+	}
+	if buf[1] != socksConnect {
+		socksReply(conn, socksCmdNotSupported) // nolint
+		return "", fmt.Errorf("unsupported command %d", buf[1])
+	}
+	var host string
+	switch buf[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if buf[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAddrNotSupport) // nolint
+		return "", fmt.Errorf("unsupported address type %d", buf[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends a reply with an unspecified bound address.
+func socksReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socksRequest sends a SOCKS5 request for cmd and addr and returns the
+// reply code.
+func socksRequest(t *testing.T, proxy string, cmd byte, addr string) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", proxy)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte{5, 1, 0})
+	require.NoError(t, err)
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 0}, reply)
+
+	host, portStr, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	req := append([]byte{5, cmd, 0, 3, byte(len(host))}, host...)
+	_, err = conn.Write(append(req, byte(port>>8), byte(port)))
+	require.NoError(t, err)
+	reply = make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+
+	return conn, reply[1]
+}
+
+func TestRemote_ServeSOCKS(t *testing.T) {
+	defer noAgent()()
+	r, server := newPasswordRemote(t)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer web.Close()
+
+	tunnel, err := r.ServeSOCKS("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Logf("addr = %s", tunnel.Addr())
+
+	// HTTP through the proxy.
+	proxyURL, err := url.Parse("socks5://" + tunnel.Addr().String())
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(web.URL)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	client.CloseIdleConnections()
+
+	// A raw connection with a host name resolved by the remote host.
+	echoAddr := startEchoServer(t)
+	_, port, err := net.SplitHostPort(echoAddr)
+	require.NoError(t, err)
+	conn, code := socksRequest(t, tunnel.Addr().String(), 1, net.JoinHostPort("localhost", port))
+	assert.Equal(t, byte(0), code)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+	conn.Close()
+	stats := waitStats(t, tunnel)
+	assert.Equal(t, int64(2), stats.Connections)
+	assert.True(t, stats.BytesReceived > 0)
+
+	// Failures are reported.
+	conn, code = socksRequest(t, tunnel.Addr().String(), 2, echoAddr)
+	conn.Close()
+	assert.Equal(t, byte(7), code)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+	conn, code = socksRequest(t, tunnel.Addr().String(), 1, closed)
+	conn.Close()
+	assert.Equal(t, byte(5), code)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-tunnel.Errors():
+			t.Logf("err = %v", err)
+			assert.Error(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no tunnel error")
+		}
+	}
+
+	require.NoError(t, tunnel.Close())
+	assert.Equal(t, 1, server.Connections())
+}
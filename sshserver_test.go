@@ -0,0 +1,460 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testSSHServer is an SSH server on the loopback interface that runs
+// exec requests with /bin/sh. Authentication is configured by the
+// ssh.ServerConfig passed to newTestSSHServer().
+type testSSHServer struct {
+	Host string
+	Port int
+
+	// RefuseAgentForwarding makes the server refuse agent
+	// forwarding requests.
+	RefuseAgentForwarding bool
+
+	// IgnoreKeepalives makes the server never reply to keepalive
+	// requests, like a host that went away.
+	IgnoreKeepalives bool
+
+	// Subsystems maps subsystem names to their handlers, which
+	// return the exit status.
+	Subsystems map[string]func(rw io.ReadWriter, stderr io.Writer) int
+
+	config   *ssh.ServerConfig
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu          sync.Mutex
+	agentKeys   []string
+	connections int
+	conns       []net.Conn
+}
+
+// newTestSSHServer starts a server. It is stopped when the test ends.
+func newTestSSHServer(t *testing.T, config *ssh.ServerConfig) *testSSHServer {
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	require.NoError(t, err)
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testSSHServer{
+		Host:     "127.0.0.1",
+		Port:     listener.Addr().(*net.TCPAddr).Port,
+		config:   config,
+		listener: listener,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Credentials returns credentials for the server with the given user
+// name.
+func (s *testSSHServer) Credentials(username string) run.Credentials {
+	return run.Credentials{
+		Hostname: s.Host,
+		Port:     s.Port,
+		Username: username,
+	}
+}
+
+// Close stops the server.
+func (s *testSSHServer) Close() {
+	s.listener.Close() // nolint
+	s.wg.Wait()
+}
+
+func (s *testSSHServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close() // nolint
+		return
+	}
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+	forwards := newTestForwards(sconn, s.IgnoreKeepalives)
+	defer forwards.close()
+	go forwards.handleRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.handleSession(sconn, channel, requests)
+		case "direct-tcpip":
+			var payload struct {
+				DestAddr string
+				DestPort uint32
+				OrigAddr string
+				OrigPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error()) // nolint
+				continue
+			}
+			go acceptDirect(newChannel, "tcp", net.JoinHostPort(payload.DestAddr, strconv.Itoa(int(payload.DestPort))))
+		case "direct-streamlocal@openssh.com":
+			var payload struct {
+				SocketPath string
+				Reserved0  string
+				Reserved1  uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error()) // nolint
+				continue
+			}
+			go acceptDirect(newChannel, "unix", payload.SocketPath)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type") // nolint
+		}
+	}
+}
+
+// DropConnections closes the connections accepted so far, like a host
+// that went away.
+func (s *testSSHServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close() // nolint
+	}
+	s.conns = nil
+}
+
+// Connections returns the number of connections accepted.
+func (s *testSSHServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections
+}
+
+// acceptDirect accepts a channel opened by the client and connects it
+// to addr.
+func acceptDirect(newChannel ssh.NewChannel, network string, addr string) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error()) // nolint
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close() // nolint
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	proxy(channel, conn)
+}
+
+// proxy copies data between a and b until both are done and closes
+// them.
+func proxy(a io.ReadWriteCloser, b io.ReadWriteCloser) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b) // nolint
+		closeWrite(a)
+		close(done)
+	}()
+	io.Copy(b, a) // nolint
+	closeWrite(b)
+	<-done
+	a.Close() // nolint
+	b.Close() // nolint
+}
+
+func closeWrite(c io.Closer) {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite() // nolint
+	} else {
+		c.Close() // nolint
+	}
+}
+
+// testForwards handles the remote port forwarding requests of a
+// connection.
+type testForwards struct {
+	conn      ssh.Conn
+	ignoreKA  bool
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func newTestForwards(conn ssh.Conn, ignoreKeepalives bool) *testForwards {
+	return &testForwards{conn: conn, ignoreKA: ignoreKeepalives, listeners: make(map[string]net.Listener)}
+}
+
+func (f *testForwards) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, l := range f.listeners {
+		l.Close() // nolint
+	}
+}
+
+func (f *testForwards) handleRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		switch req.Type {
+		case "tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil) // nolint
+				continue
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+			if err != nil {
+				req.Reply(false, nil) // nolint
+				continue
+			}
+			port := uint32(listener.Addr().(*net.TCPAddr).Port)
+			f.mu.Lock()
+			f.listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+			f.mu.Unlock()
+			req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port})) // nolint
+			go f.serve(listener, payload.Addr, port)
+		case "cancel-tcpip-forward":
+			if ssh.Unmarshal(req.Payload, &payload) != nil {
+				req.Reply(false, nil) // nolint
+				continue
+			}
+			key := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+			f.mu.Lock()
+			listener, ok := f.listeners[key]
+			delete(f.listeners, key)
+			f.mu.Unlock()
+			if ok {
+				listener.Close() // nolint
+			}
+			req.Reply(ok, nil) // nolint
+		case "keepalive@openssh.com":
+			if !f.ignoreKA {
+				req.Reply(false, nil) // nolint
+			}
+		default:
+			if req.WantReply {
+				req.Reply(false, nil) // nolint
+			}
+		}
+	}
+}
+
+func (f *testForwards) serve(listener net.Listener, addr string, port uint32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		origin := conn.RemoteAddr().(*net.TCPAddr)
+		payload := struct {
+			Addr       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}{addr, port, origin.IP.String(), uint32(origin.Port)}
+		channel, reqs, err := f.conn.OpenChannel("forwarded-tcpip", ssh.Marshal(&payload))
+		if err != nil {
+			conn.Close() // nolint
+			continue
+		}
+		go ssh.DiscardRequests(reqs)
+		go proxy(channel, conn)
+	}
+}
+
+// AgentKeys returns the comments of the keys listed through forwarded
+// agents.
+func (s *testSSHServer) AgentKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.agentKeys...)
+}
+
+// listAgentKeys lists the keys of the agent forwarded by the client.
+func (s *testSSHServer) listAgentKeys(conn ssh.Conn) error {
+	channel, reqs, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return err
+	}
+	defer channel.Close() // nolint
+	go ssh.DiscardRequests(reqs)
+	keys, err := agent.NewClient(channel).List()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.agentKeys = append(s.agentKeys, key.Comment)
+	}
+
+	return nil
+}
+
+func (s *testSSHServer) handleSession(conn ssh.Conn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close() // nolint
+	for req := range requests {
+		if req.Type == "auth-agent-req@openssh.com" {
+			ok := !s.RefuseAgentForwarding && s.listAgentKeys(conn) == nil
+			req.Reply(ok, nil) // nolint
+			continue
+		}
+		if req.Type == "subsystem" {
+			var payload struct{ Name string }
+			ssh.Unmarshal(req.Payload, &payload) // nolint
+			handler, ok := s.Subsystems[payload.Name]
+			req.Reply(ok, nil) // nolint
+			if !ok {
+				continue
+			}
+			status := struct{ Status uint32 }{uint32(handler(channel, channel.Stderr()))}
+			channel.SendRequest("exit-status", false, ssh.Marshal(&status)) // nolint
+			return
+		}
+		if req.Type != "exec" {
+			req.Reply(false, nil) // nolint
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil) // nolint
+			continue
+		}
+		req.Reply(true, nil) // nolint
+		cmd := exec.Command("/bin/sh", "-c", payload.Command)
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		code := 0
+		if err := cmd.Run(); err != nil {
+			code = 255
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+			}
+		}
+		status := struct{ Status uint32 }{uint32(code)}
+		channel.SendRequest("exit-status", false, ssh.Marshal(&status)) // nolint
+		return
+	}
+}
+
+// writeTestKey writes a new ECDSA private key in PEM format to dir,
+// encrypted if passphrase is not empty, and returns the file name and
+// the public key.
+func writeTestKey(t *testing.T, dir string, name string, passphrase string) (string, ssh.PublicKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		// nolint: staticcheck
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES256)
+		require.NoError(t, err)
+	}
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600))
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	return filename, pub
+}
+
+// writeTestOpenSSHKey writes key in the OpenSSH format to dir,
+// encrypted if passphrase is not empty, and returns the file name and
+// the public key.
+func writeTestOpenSSHKey(t *testing.T, dir string, name string, key crypto.PrivateKey, passphrase string) (string, ssh.PublicKey) {
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(key, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+	}
+	require.NoError(t, err)
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600))
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return filename, signer.PublicKey()
+}
+
+// writeTestPublicKey writes pub in authorized_keys format.
+func writeTestPublicKey(t *testing.T, filename string, pub ssh.PublicKey) {
+	require.NoError(t, ioutil.WriteFile(filename, ssh.MarshalAuthorizedKey(pub), 0644))
+}
+
+// startTestAgent starts an ssh-agent holding keys and returns the path
+// of its socket in dir. It is stopped when the test ends.
+func startTestAgent(t *testing.T, dir string, keys ...agent.AddedKey) string {
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		require.NoError(t, keyring.Add(key))
+	}
+	sock := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn) // nolint
+		}
+	}()
+
+	return sock
+}
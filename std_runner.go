@@ -4,24 +4,65 @@
 
 package run
 
+import "sync"
+
 var (
 	// The standard runner is used to run local commands without
 	// the need to explicitly use a constructor.
-	std = NewLocal(LocalConfig{})
+	std   Runner = NewLocal(LocalConfig{})
+	stdMu sync.RWMutex
 )
 
+// standard returns the standard runner.
+func standard() Runner {
+	stdMu.RLock()
+	defer stdMu.RUnlock()
+
+	return std
+}
+
+// StandardRunner returns the runner used by the package-level Run(),
+// FormatRun(), Shell(), and FormatShell() functions. It is a Local
+// created with the default LocalConfig unless it has been replaced by
+// SetStandardRunner() or OpenStandardRunner().
+func StandardRunner() Runner {
+	return standard()
+}
+
+// SetStandardRunner replaces the runner used by the package-level
+// Run(), FormatRun(), Shell(), and FormatShell() functions, e.g., so
+// a tool can run its commands on a target given on the command line.
+func SetStandardRunner(runner Runner) {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	std = runner
+}
+
+// OpenStandardRunner replaces the standard runner with the Runner
+// returned by Open(). The standard runner is unchanged if Open()
+// fails.
+func OpenStandardRunner(rawurl string, opts ...OpenOption) error {
+	runner, err := Open(rawurl, opts...)
+	if err != nil {
+		return err
+	}
+	SetStandardRunner(runner)
+
+	return nil
+}
+
 // Run runs a command like glibc's exec() call using the standard
 // runner. It returns the standard out, standard error, and exit code
 // of the command when it completes.
 func Run(cmd string, args ...string) (string, string, int, error) {
-	return std.Run(cmd, args...)
+	return standard().Run(cmd, args...)
 }
 
 // FormatRun returns a string representation of the what command would
 // be run using the standard runner's Run() method. Useful for logging
 // commands.
 func FormatRun(cmd string, args ...string) string {
-	return std.FormatRun(cmd, args...)
+	return standard().FormatRun(cmd, args...)
 }
 
 // Shell runs a command in a shell using the standard runner. The
@@ -30,12 +71,12 @@ func FormatRun(cmd string, args ...string) string {
 // it. It returns the standard out, standard error, and exit code of
 // the command when it completes
 func Shell(cmd string) (string, string, int, error) {
-	return std.Shell(cmd)
+	return standard().Shell(cmd)
 }
 
 // FormatShell returns a string representation of the what command
 // would be run using the standard runner's Shell() method. Useful
 // for logging commands.
 func FormatShell(cmd string) string {
-	return std.FormatShell(cmd)
+	return standard().FormatShell(cmd)
 }
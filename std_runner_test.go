@@ -11,6 +11,7 @@ import (
 
 	"github.com/apatters/go-run"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStdRunner_RunSuccess(t *testing.T) {
@@ -125,3 +126,22 @@ func TestStdRunner_FormatShell(t *testing.T) {
 
 	assert.Equal(t, msg, `/bin/sh -c "uname -a"`)
 }
+
+func TestStdRunner_SetStandardRunner(t *testing.T) {
+	orig := run.StandardRunner()
+	defer run.SetStandardRunner(orig)
+
+	err := run.OpenStandardRunner("local:///tmp")
+	require.NoError(t, err)
+	stdout, _, code, err := run.Run("pwd")
+	require.NoError(t, err)
+	assert.Zero(t, code)
+	assert.Equal(t, "/tmp\n", stdout)
+
+	err = run.OpenStandardRunner("nope://")
+	assert.Error(t, err)
+	assert.Equal(t, "/tmp", run.StandardRunner().(*run.Local).Dir)
+
+	run.SetStandardRunner(orig)
+	assert.Equal(t, "", run.StandardRunner().(*run.Local).Dir)
+}
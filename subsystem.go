@@ -0,0 +1,105 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SubsystemStream is a subsystem, such as "netconf" or "sftp", running
+// on a remote host. It is an io.ReadWriteCloser that reads the
+// subsystem's standard output and writes its standard input, so it can
+// be passed to protocol libraries, e.g., the netconf package.
+type SubsystemStream struct {
+	// Name is the name of the subsystem.
+	Name string
+
+	// Stdin writes to the subsystem's standard input. Closing it
+	// sends EOF.
+	Stdin io.WriteCloser
+
+	// Stdout reads the subsystem's standard output.
+	Stdout io.Reader
+
+	// Stderr reads the subsystem's standard error. If the
+	// subsystem writes to it, it must be read or the subsystem
+	// eventually blocks.
+	Stderr io.Reader
+
+	remote  *Remote
+	session *ssh.Session
+	release func()
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Subsystem starts the named subsystem on the remote host, like "ssh
+// -s". It uses the shared connection if it is open and a connection of
+// its own otherwise, which is closed by SubsystemStream.Close(). It is
+// an error if the server does not provide the subsystem. The exit
+// status of the subsystem is not reported; Stdout reaches EOF when it
+// exits.
+func (r *Remote) Subsystem(name string) (*SubsystemStream, error) {
+	session, _, release, err := r.newSession(context.Background(), false)
+	if err != nil {
+		return nil, r.redactError(notStarted(err))
+	}
+	s := &SubsystemStream{
+		Name:    name,
+		remote:  r,
+		session: session,
+		release: release,
+	}
+	if s.Stdin, err = session.StdinPipe(); err == nil {
+		if s.Stdout, err = session.StdoutPipe(); err == nil {
+			s.Stderr, err = session.StderrPipe()
+		}
+	}
+	if err == nil {
+		err = session.RequestSubsystem(name)
+	}
+	if err != nil {
+		release()
+		return nil, r.redactError(notStarted(fmt.Errorf("run: cannot start subsystem %q on %s: %w", name, r.Credentials.Hostname, err)))
+	}
+	logAttrs(r.Logger, slog.LevelDebug, "subsystem start",
+		append(r.logAttrs(), slog.String("subsystem", name))...)
+
+	return s, nil
+}
+
+// Read reads the subsystem's standard output.
+func (s *SubsystemStream) Read(p []byte) (int, error) {
+	return s.Stdout.Read(p)
+}
+
+// Write writes to the subsystem's standard input.
+func (s *SubsystemStream) Write(p []byte) (int, error) {
+	return s.Stdin.Write(p)
+}
+
+// Close closes the subsystem's session and, if it has its own, the
+// connection. It returns the error of closing the session, unless the
+// session was already closed because the subsystem exited.
+func (s *SubsystemStream) Close() error {
+	s.closeOnce.Do(func() {
+		if err := s.session.Close(); err != nil && err != io.EOF {
+			s.closeErr = s.remote.redactError(fmt.Errorf("run: cannot close subsystem %q on %s: %w",
+				s.Name, s.remote.Credentials.Hostname, err))
+		}
+		s.release()
+		logAttrs(s.remote.Logger, slog.LevelDebug, "subsystem close",
+			append(s.remote.logAttrs(), slog.String("subsystem", s.Name))...)
+	})
+
+	return s.closeErr
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/apatters/go-run/netconf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemote_Subsystem(t *testing.T) {
+	r, server := newPasswordRemote(t)
+	server.Subsystems = map[string]func(io.ReadWriter, io.Writer) int{
+		"echo": func(rw io.ReadWriter, stderr io.Writer) int {
+			io.Copy(rw, rw)                       // nolint
+			io.WriteString(stderr, "echo done\n") // nolint
+			return 0
+		},
+	}
+
+	s, err := r.Subsystem("echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", s.Name)
+	_, err = io.WriteString(s, "hello subsystem")
+	require.NoError(t, err)
+	require.NoError(t, s.Stdin.Close())
+	stdout, err := ioutil.ReadAll(s)
+	require.NoError(t, err)
+	assert.Equal(t, "hello subsystem", string(stdout))
+	stderr, err := ioutil.ReadAll(s.Stderr)
+	require.NoError(t, err)
+	assert.Equal(t, "echo done\n", string(stderr))
+	assert.NoError(t, s.Close())
+	assert.NoError(t, s.Close())
+
+	// The shared connection carries subsystems too.
+	require.NoError(t, r.Connect())
+	_, err = r.Subsystem("missing")
+	t.Logf("err = %v", err)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `run: cannot start subsystem "missing"`)
+	s, err = r.Subsystem("echo")
+	require.NoError(t, err)
+	s.Close() // nolint
+}
+
+func TestRemote_SubsystemNETCONF(t *testing.T) {
+	r, server := newPasswordRemote(t)
+	server.Subsystems = map[string]func(io.ReadWriter, io.Writer) int{
+		"netconf": func(rw io.ReadWriter, stderr io.Writer) int {
+			c := netconf.NewConn(rw)
+			if _, err := c.ReadMessage(); err != nil {
+				return 1
+			}
+			hello, _ := xml.Marshal(&netconf.Hello{ // nolint
+				Capabilities: []string{netconf.Base10, netconf.Base11},
+				SessionID:    7,
+			})
+			if c.WriteMessage(hello) != nil {
+				return 1
+			}
+			c.SetChunked(true)
+			for {
+				msg, err := c.ReadMessage()
+				if err != nil {
+					return 0
+				}
+				reply := `<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><data>` +
+					string(msg) + `</data></rpc-reply>`
+				if c.WriteMessage([]byte(reply)) != nil {
+					return 1
+				}
+			}
+		},
+	}
+
+	s, err := r.Subsystem("netconf")
+	require.NoError(t, err)
+	defer s.Close() // nolint
+	c := netconf.NewConn(s)
+	hello, err := c.Hello()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), hello.SessionID)
+	assert.True(t, c.Chunked())
+	require.NoError(t, c.WriteMessage([]byte(`<rpc message-id="1"><get/></rpc>`)))
+	reply, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Contains(t, string(reply), `<data><rpc message-id="1"><get/></rpc></data>`)
+	require.NoError(t, s.Stdin.Close())
+	_, err = c.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
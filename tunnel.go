@@ -0,0 +1,305 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// tunnelErrorsSize is the number of errors buffered by Tunnel.Errors().
+const tunnelErrorsSize = 16
+
+// TunnelStats contains the counters of a Tunnel.
+type TunnelStats struct {
+	// Connections is the number of connections accepted.
+	Connections int64
+
+	// Active is the number of connections being forwarded.
+	Active int64
+
+	// BytesSent is the number of bytes sent from the listening
+	// side to the target.
+	BytesSent int64
+
+	// BytesReceived is the number of bytes sent from the target
+	// back to the listening side.
+	BytesReceived int64
+}
+
+// Tunnel forwards the connections accepted on one side of a Remote's
+// shared connection to an address on the other side. Tunnels are
+// created by Remote.ForwardLocal(), Remote.ForwardRemote(), and
+// Remote.ServeSOCKS() and run until Close() is called.
+type Tunnel struct {
+	// The counters are accessed atomically and must be 64-bit
+	// aligned.
+	connections   int64
+	active        int64
+	bytesSent     int64
+	bytesReceived int64
+
+	remote   *Remote
+	name     string
+	listener net.Listener
+	dial     func(conn net.Conn) (net.Conn, error)
+	errs     chan error
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	closeErr error
+}
+
+// newTunnel starts forwarding the connections accepted by listener to
+// the connections returned by dial, which is called with the accepted
+// connection.
+func newTunnel(r *Remote, name string, listener net.Listener, dial func(conn net.Conn) (net.Conn, error)) *Tunnel {
+	t := &Tunnel{
+		remote:   r,
+		name:     name,
+		listener: listener,
+		dial:     dial,
+		errs:     make(chan error, tunnelErrorsSize),
+		done:     make(chan struct{}),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	t.wg.Add(1)
+	go t.serve()
+	logAttrs(r.Logger, slog.LevelInfo, "ssh tunnel opened",
+		append(r.logAttrs(), slog.String("tunnel", name))...)
+
+	return t
+}
+
+// Addr returns the address the tunnel listens on. It is useful to find
+// the port chosen when listening on port 0.
+func (t *Tunnel) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Errors returns a channel receiving the errors of the tunnel, e.g.,
+// when the target cannot be reached. Errors are dropped if the channel
+// is full. The channel is closed by Close().
+func (t *Tunnel) Errors() <-chan error {
+	return t.errs
+}
+
+// Stats returns the counters of the tunnel.
+func (t *Tunnel) Stats() TunnelStats {
+	return TunnelStats{
+		Connections:   atomic.LoadInt64(&t.connections),
+		Active:        atomic.LoadInt64(&t.active),
+		BytesSent:     atomic.LoadInt64(&t.bytesSent),
+		BytesReceived: atomic.LoadInt64(&t.bytesReceived),
+	}
+}
+
+// Close stops listening, closes the connections being forwarded, and
+// waits for them to finish.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return t.closeErr
+	}
+	t.closed = true
+	close(t.done)
+	t.closeErr = t.listener.Close()
+	for conn := range t.conns {
+		conn.Close() // nolint
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	close(t.errs)
+	t.remote.removeTunnel(t)
+	stats := t.Stats()
+	logAttrs(t.remote.Logger, slog.LevelInfo, "ssh tunnel closed",
+		append(t.remote.logAttrs(),
+			slog.String("tunnel", t.name),
+			slog.Int64("connections", stats.Connections),
+			slog.Int64("bytes_sent", stats.BytesSent),
+			slog.Int64("bytes_received", stats.BytesReceived))...)
+
+	return t.closeErr
+}
+
+// error reports err on the errors channel.
+func (t *Tunnel) error(err error) {
+	err = t.remote.redactError(fmt.Errorf("run: tunnel %s: %w", t.name, err))
+	logAttrs(t.remote.Logger, slog.LevelError, "ssh tunnel error",
+		append(t.remote.logAttrs(),
+			slog.String("tunnel", t.name),
+			slog.String("err", err.Error()))...)
+	select {
+	case t.errs <- err:
+	default:
+	}
+}
+
+// track adds conn to the connections closed by Close(). It returns
+// false if the tunnel is closed.
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+func (t *Tunnel) serve() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.done:
+			default:
+				t.error(fmt.Errorf("accept: %w", err))
+			}
+			return
+		}
+		atomic.AddInt64(&t.connections, 1)
+		t.wg.Add(1)
+		go t.forward(conn)
+	}
+}
+
+// forward copies data between conn and a new connection to the target
+// until both directions are closed.
+func (t *Tunnel) forward(conn net.Conn) {
+	defer t.wg.Done()
+	defer conn.Close() // nolint
+	if !t.track(conn) {
+		return
+	}
+	defer t.untrack(conn)
+	target, err := t.dial(conn)
+	if err != nil {
+		t.error(fmt.Errorf("dial: %w", err))
+		return
+	}
+	defer target.Close() // nolint
+	if !t.track(target) {
+		return
+	}
+	defer t.untrack(target)
+
+	atomic.AddInt64(&t.active, 1)
+	defer atomic.AddInt64(&t.active, -1)
+	copied := make(chan struct{})
+	go func() {
+		pipe(target, conn, &t.bytesSent)
+		close(copied)
+	}()
+	pipe(conn, target, &t.bytesReceived)
+	<-copied
+}
+
+// pipe copies src to dst, adding the number of bytes copied to n, and
+// then closes the writing side of dst.
+func pipe(dst net.Conn, src net.Conn, n *int64) {
+	io.Copy(&atomicCountingWriter{w: dst, n: n}, src) // nolint
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite() // nolint
+	} else {
+		dst.Close() // nolint
+	}
+}
+
+// atomicCountingWriter counts the bytes written to w in a counter that
+// is read concurrently.
+type atomicCountingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *atomicCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+
+	return n, err
+}
+
+// ForwardLocal listens on localAddr on the local host, like "ssh -L",
+// and forwards the connections it accepts through the shared
+// connection to remoteAddr, which is reached from the remote host,
+// e.g., "127.0.0.1:5432" for a database listening on the remote host's
+// loopback interface. Use port 0 in localAddr to choose a free port
+// and Tunnel.Addr() to find it.
+func (r *Remote) ForwardLocal(localAddr string, remoteAddr string) (*Tunnel, error) {
+	if err := r.Connect(); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot forward %s to %s: %w", localAddr, remoteAddr, err)
+	}
+	name := fmt.Sprintf("local %s -> remote %s", listener.Addr(), remoteAddr)
+	t := newTunnel(r, name, listener, func(net.Conn) (net.Conn, error) {
+		client, err := r.sharedClient(context.Background(), false)
+		if err != nil {
+			return nil, err
+		}
+		return client.Dial("tcp", remoteAddr)
+	})
+	r.addTunnel(t)
+
+	return t, nil
+}
+
+// ForwardRemote listens on remoteAddr on the remote host, like "ssh
+// -R", and forwards the connections it accepts through the shared
+// connection to localAddr, which is reached from the local host. The
+// SSH server decides which remote addresses may be used; OpenSSH only
+// listens on the loopback interface unless GatewayPorts is set.
+func (r *Remote) ForwardRemote(remoteAddr string, localAddr string) (*Tunnel, error) {
+	client, err := r.sharedClient(context.Background(), true)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := client.Listen("tcp", remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("run: cannot forward %s:%s to %s: %w", r.Credentials.Hostname, remoteAddr, localAddr, err)
+	}
+	name := fmt.Sprintf("remote %s -> local %s", listener.Addr(), localAddr)
+	t := newTunnel(r, name, listener, func(net.Conn) (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	})
+	r.addTunnel(t)
+
+	return t, nil
+}
+
+func (r *Remote) addTunnel(t *Tunnel) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	if r.tunnels == nil {
+		r.tunnels = make(map[*Tunnel]struct{})
+	}
+	r.tunnels[t] = struct{}{}
+}
+
+func (r *Remote) removeTunnel(t *Tunnel) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	delete(r.tunnels, t)
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Secure64 Software Corporation. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can
+// be found in the LICENSE file.
+
+package run_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apatters/go-run"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a TCP server echoing what it receives and
+// returns its address.
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() }) // nolint
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn) // nolint
+				conn.Close()        // nolint
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// newPasswordRemote returns a Remote for a test server accepting a
+// password.
+func newPasswordRemote(t *testing.T) (*run.Remote, *testSSHServer) {
+	server := newTestSSHServer(t, authServerConfig("s3cret"))
+	creds := server.Credentials("deploy")
+	creds.Password = "s3cret"
+	r, err := run.NewRemote(run.RemoteConfig{Credentials: creds})
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() }) // nolint
+
+	return r, server
+}
+
+// echo writes msg to the tunnel listening on addr and returns the
+// reply.
+func echo(t *testing.T, addr string, msg string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	reply, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(reply)
+}
+
+// waitStats waits until the tunnel has no active connections.
+func waitStats(t *testing.T, tunnel *run.Tunnel) run.TunnelStats {
+	for i := 0; i < 100; i++ {
+		if stats := tunnel.Stats(); stats.Active == 0 {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("tunnel still active: %+v", tunnel.Stats())
+
+	return run.TunnelStats{}
+}
+
+func TestRemote_SharedConnection(t *testing.T) {
+	defer noAgent()()
+	r, server := newPasswordRemote(t)
+
+	// Commands use a connection of their own.
+	_, _, _, err := r.Run("/bin/true")
+	require.NoError(t, err)
+	_, _, _, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.Connections())
+
+	// Commands use the shared connection.
+	require.NoError(t, r.Connect())
+	require.NoError(t, r.Connect())
+	stdout, _, _, err := r.Run("echo", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", stdout)
+	_, _, _, err = r.Shell("true")
+	require.NoError(t, err)
+	assert.Equal(t, 3, server.Connections())
+
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	_, _, _, err = r.Run("/bin/true")
+	require.NoError(t, err)
+	assert.Equal(t, 4, server.Connections())
+}
+
+func TestRemote_ForwardLocal(t *testing.T) {
+	defer noAgent()()
+	r, server := newPasswordRemote(t)
+	target := startEchoServer(t)
+
+	tunnel, err := r.ForwardLocal("127.0.0.1:0", target)
+	require.NoError(t, err)
+	t.Logf("addr = %s", tunnel.Addr())
+	assert.Equal(t, "hello", echo(t, tunnel.Addr().String(), "hello"))
+	assert.Equal(t, "world!", echo(t, tunnel.Addr().String(), "world!"))
+	assert.Equal(t, run.TunnelStats{Connections: 2, BytesSent: 11, BytesReceived: 11}, waitStats(t, tunnel))
+	assert.Equal(t, 1, server.Connections())
+
+	// An unreachable target is reported.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+	bad, err := r.ForwardLocal("127.0.0.1:0", closed)
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", bad.Addr().String())
+	require.NoError(t, err)
+	reply, _ := ioutil.ReadAll(conn)
+	conn.Close()
+	assert.Empty(t, reply)
+	select {
+	case err := <-bad.Errors():
+		t.Logf("err = %v", err)
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no tunnel error")
+	}
+
+	// Close closes the error channel and stops listening.
+	require.NoError(t, tunnel.Close())
+	_, ok := <-tunnel.Errors()
+	assert.False(t, ok)
+	_, err = net.Dial("tcp", tunnel.Addr().String())
+	assert.Error(t, err)
+
+	// Closing the Remote closes its tunnels.
+	require.NoError(t, r.Close())
+	for range bad.Errors() {
+	}
+	_, err = net.Dial("tcp", bad.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestRemote_ForwardRemote(t *testing.T) {
+	defer noAgent()()
+	r, _ := newPasswordRemote(t)
+	target := startEchoServer(t)
+
+	tunnel, err := r.ForwardRemote("127.0.0.1:0", target)
+	require.NoError(t, err)
+	t.Logf("addr = %s", tunnel.Addr())
+	assert.Equal(t, "hello", echo(t, tunnel.Addr().String(), "hello"))
+	assert.Equal(t, run.TunnelStats{Connections: 1, BytesSent: 5, BytesReceived: 5}, waitStats(t, tunnel))
+
+	require.NoError(t, tunnel.Close())
+	_, ok := <-tunnel.Errors()
+	assert.False(t, ok)
+}